@@ -3,21 +3,49 @@ package main
 import (
 	"flag"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/jw-s/updatey/pkg/client/docker"
 
 	"github.com/jw-s/updatey/pkg/admission"
+	"github.com/jw-s/updatey/pkg/controller"
 	"github.com/jw-s/updatey/pkg/k8s"
 	"github.com/jw-s/updatey/pkg/version"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 var (
-	cert = flag.String("cert", "/certs/tls.crt", "path location to TLS certificate")
-	key  = flag.String("key", "/certs/tls.key", "path location to TLS private key")
+	cert              = flag.String("cert", "/certs/tls.crt", "path location to TLS certificate")
+	key               = flag.String("key", "/certs/tls.key", "path location to TLS private key")
+	reconcileInterval = flag.Duration("reconcile-interval", 0, "how often workloads are re-resolved in the background, 0 disables reconciliation")
+	reconcileWorkers  = flag.Int("reconcile-workers", 2, "number of workloads which are reconciled concurrently")
+	pinDigest         = flag.Bool("pin-digest", false, "pin every image resolved from a constraint to its content digest, objects can opt in individually with the updatey/pin-digest annotation")
+	credentials       = flag.String("credential-strategy", string(k8s.AuthFirst), "order in which credentials are tried when listing tags, either auth-first, anonymous-first or auth-only")
+	dockerConfig      = flag.String("docker-config", "", "path to a docker config.json whose credentials, credential helpers and credentials store are used alongside image pull secrets")
+	secretCache       = flag.Bool("secret-cache", false, "serve image pull secrets from an informer cache instead of retrieving them for every admission request")
+	tagCacheTTL       = flag.Duration("tag-cache-ttl", time.Minute, "how long the tags of a repository are cached, 0 disables caching")
+	tagCacheStats     = flag.Duration("tag-cache-stats-interval", 10*time.Minute, "how often the hits, misses and stale lookups of the tag cache are logged, 0 disables logging them")
+	resolveTimeout    = flag.Duration("resolve-timeout", 25*time.Second, "how long resolving all images of an object may take, should be below the webhook timeout")
+	concurrency       = flag.Int("resolve-concurrency", 4, "number of images of an object which are resolved concurrently")
+	registryTimeout   = flag.Duration("registry-timeout", 5*time.Minute, "how long a single call to a registry may take")
+	registryRetries   = flag.Int("registry-retries", 3, "how often a registry call failing with a transient status like 429 or 502 is retried within its timeout, 0 disables retries")
+	tagPageSize       = flag.Int("tag-page-size", 1000, "number of tags requested per page when listing the tags of a repository")
+	maxTags           = flag.Int("max-tags", 0, "maximum number of tags listed per repository, further tags are ignored and may include the newest versions as registries list tags lexically, 0 disables the cap")
+	pingTimeout       = flag.Duration("ping-timeout", 15*time.Second, "how long the initial ping of a registry may take")
+	registryConfig    = flag.String("registry-config", "", "path to a yaml registry config listing the mirrors tried before each registry, their tls options and rate limits")
+	platforms         = flag.String("platforms", "", "comma separated platforms like linux/amd64,linux/arm64 resolved images have to publish when a pod doesn't constrain the architecture of its nodes, empty disables the check for such pods")
+	minAge            = flag.Duration("min-age", 0, "how long ago the image of a tag has to be created before it is chosen, objects can override it with the updatey/min-age annotation, 0 disables the cooldown")
+	optIn             = flag.Bool("opt-in", false, "only touch objects with the updatey/enabled: \"true\" annotation instead of every object without updatey/enabled: \"false\"")
+	includeNamespaces = flag.String("include-namespaces", "", "comma separated namespaces whose objects are touched, empty includes every namespace, kube-system is never touched")
+	excludeNamespaces = flag.String("exclude-namespaces", "", "comma separated namespaces whose objects are never touched, takes precedence over -include-namespaces")
+	resolverMode      = flag.String("resolver", "semver", "version resolver to use, either semver or variant to respect variant suffixes like -alpine")
 )
 
 func init() {
@@ -37,12 +65,133 @@ func main() {
 		panic(err)
 	}
 
-	wrapper := k8s.New(k8s.NewSecretRetriever(kubeClient.CoreV1()), version.NewSemVersionResolver(), &docker.Client{})
+	var resolver version.Resolver
+	switch *resolverMode {
+	case "semver":
+		resolver = version.NewSemVersionResolver()
+	case "variant":
+		resolver = version.NewVariantResolver()
+	default:
+		glog.Fatalf("unknown resolver: %s", *resolverMode)
+	}
+
+	credentialStrategy, err := k8s.ParseCredentialStrategy(*credentials)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	var registries *docker.RegistryConfig
+	if *registryConfig != "" {
+		registries, err = docker.LoadRegistryConfig(*registryConfig)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	var dockerClient docker.Interface = &docker.Client{
+		Timeout:     *registryTimeout,
+		PingTimeout: *pingTimeout,
+		Registries:  registries,
+		Retries:     *registryRetries,
+		PageSize:    *tagPageSize,
+		MaxTags:     *maxTags,
+	}
+	if *tagCacheTTL > 0 {
+		cachedClient := docker.NewCachedClient(dockerClient, *tagCacheTTL)
+		cachedClient.Timeout = *registryTimeout
+		dockerClient = cachedClient
+
+		if *tagCacheStats > 0 {
+			go logCacheStats(cachedClient, *tagCacheStats)
+		}
+	}
+
+	var secretRetriever k8s.SecretInterface = k8s.NewSecretRetriever(kubeClient.CoreV1())
+	if *secretCache {
+		cachedSecretRetriever := k8s.NewCachedSecretRetriever(kubeClient, secretRetriever)
+
+		go func() {
+			if err := cachedSecretRetriever.Run(make(chan struct{})); err != nil {
+				glog.Error(err)
+			}
+		}()
+
+		secretRetriever = cachedSecretRetriever
+	}
+
+	var credentialSources []docker.CredentialSource
+	if *dockerConfig != "" {
+		configFile, err := docker.LoadConfigFile(*dockerConfig)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		credentialSources = append(credentialSources, configFile)
+	}
+
+	var defaultPlatforms []docker.Platform
+	for _, name := range splitList(*platforms) {
+		platform, err := docker.ParsePlatform(name)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		defaultPlatforms = append(defaultPlatforms, platform)
+	}
+
+	wrapper := k8s.New(secretRetriever, resolver, dockerClient,
+		k8s.WithDigestPinning(*pinDigest),
+		k8s.WithResolveTimeout(*resolveTimeout),
+		k8s.WithConcurrency(*concurrency),
+		k8s.WithCredentialStrategy(credentialStrategy),
+		k8s.WithCredentialSources(credentialSources...),
+		k8s.WithServiceAccounts(k8s.NewServiceAccountRetriever(kubeClient.CoreV1())),
+		k8s.WithPlatforms(defaultPlatforms...),
+		k8s.WithMinimumAge(*minAge),
+		k8s.WithOptIn(*optIn),
+		k8s.WithIncludedNamespaces(splitList(*includeNamespaces)...),
+		k8s.WithExcludedNamespaces(splitList(*excludeNamespaces)...),
+	)
+
+	if *reconcileInterval > 0 {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			panic(err)
+		}
+
+		informerFactory := informers.NewSharedInformerFactory(kubeClient, *reconcileInterval)
+		dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, *reconcileInterval)
+		reconciler := controller.New(kubeClient, dynamicClient, informerFactory, dynamicInformerFactory, wrapper)
+
+		go func() {
+			glog.Fatal(reconciler.Run(*reconcileWorkers, make(chan struct{})))
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", admission.ValidateHandler(wrapper))
+	mux.Handle("/", admission.AdmitHandler(wrapper))
 
 	server := &http.Server{
-		Handler: admission.AdmitHandler(wrapper),
+		Handler: mux,
 		Addr:    ":8080",
 	}
 
 	glog.Fatal(server.ListenAndServeTLS(*cert, *key))
 }
+
+// logCacheStats logs the counters of the tag cache every interval, they are counted since startup.
+func logCacheStats(cachedClient *docker.CachedClient, interval time.Duration) {
+	for range time.Tick(interval) {
+		stats := cachedClient.Stats()
+		glog.Infof("tag cache: %d hits, %d misses, %d stale", stats.Hits, stats.Misses, stats.Stale)
+	}
+}
+
+// splitList splits a comma separated flag value, ignoring empty elements.
+func splitList(value string) (list []string) {
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
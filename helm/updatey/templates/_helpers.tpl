@@ -30,3 +30,33 @@ Create chart name and version as used by the chart label.
 {{- define "updatey.chart" -}}
 {{- printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" -}}
 {{- end -}}
+
+{{/*
+Select the namespaces the webhooks are called for. kube-system and the release namespace are never selected,
+so an unavailable webhook can neither block the control plane nor keep updatey itself from starting.
+Excluded namespaces are never selected and only included namespaces are, updatey filters them as well.
+The kubernetes.io/metadata.name label is set on every namespace since Kubernetes 1.21. Older clusters match no namespace
+with In and every namespace with NotIn, so included namespaces are only selected here from 1.21 on and left to updatey before.
+*/}}
+{{- define "updatey.namespaceSelector" -}}
+namespaceSelector:
+  matchExpressions:
+    - key: kubernetes.io/metadata.name
+      operator: NotIn
+      values:
+        - kube-system
+        - {{ .Release.Namespace }}
+      {{- range .Values.namespaces.exclude }}
+        - {{ . }}
+      {{- end }}
+  {{- if semverCompare ">=1.21-0" .Capabilities.KubeVersion.GitVersion }}
+  {{- with .Values.namespaces.include }}
+    - key: kubernetes.io/metadata.name
+      operator: In
+      values:
+      {{- range . }}
+        - {{ . }}
+      {{- end }}
+  {{- end }}
+  {{- end }}
+{{- end -}}
@@ -1,8 +1,11 @@
 package admission
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/jw-s/updatey/pkg/k8s"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -10,7 +13,17 @@ import (
 	"k8s.io/api/admission/v1beta1"
 )
 
+const (
+	// V1 is the admission.k8s.io/v1 AdmissionReview API version.
+	V1 = "admission.k8s.io/v1"
+	// V1beta1 is the admission.k8s.io/v1beta1 AdmissionReview API version.
+	V1beta1 = "admission.k8s.io/v1beta1"
+
+	admissionReviewKind = "AdmissionReview"
+)
+
 // AdmitHandler is the mutating webhook handler.
+// It serves both the admission.k8s.io/v1 and v1beta1 AdmissionReview APIs and responds with the version of the request.
 func AdmitHandler(client k8s.Interface) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 
@@ -19,33 +32,24 @@ func AdmitHandler(client k8s.Interface) http.HandlerFunc {
 			return
 		}
 
-		var ar v1beta1.AdmissionReview
-		if err := json.NewDecoder(req.Body).Decode(&ar); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("unable to decode body"))
+		ar, ok := decodeReview(w, req)
+		if !ok {
 			return
 		}
 
-		patches, err := client.GetPatches(ar.Request)
+		ctx, cancel := requestContext(req)
+		defer cancel()
+
+		patches, err := client.GetPatches(ctx, ar.Request)
 
 		if err != nil {
-			ar.Response = &v1beta1.AdmissionResponse{
+			writeResponse(w, ar, &v1beta1.AdmissionResponse{
 				UID:     ar.Request.UID,
 				Allowed: false,
 				Result: &v1.Status{
 					Message: err.Error(),
 				},
-			}
-
-			resp, err := json.Marshal(ar)
-
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("unable to encode response"))
-				return
-			}
-
-			w.Write(resp)
+			})
 			return
 		}
 
@@ -56,22 +60,117 @@ func AdmitHandler(client k8s.Interface) http.HandlerFunc {
 			return
 		}
 		pt := v1beta1.PatchTypeJSONPatch
-		ar.Response = &v1beta1.AdmissionResponse{
+		writeResponse(w, ar, &v1beta1.AdmissionResponse{
 			UID:       ar.Request.UID,
 			Allowed:   true,
 			PatchType: &pt,
 			Patch:     jsonPatch,
+		})
+	}
+}
+
+// ValidateHandler is the validating webhook handler.
+// It denies objects with images which use the latest tag or still contain a version constraint.
+func ValidateHandler(client k8s.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ar, ok := decodeReview(w, req)
+		if !ok {
+			return
 		}
 
-		resp, err := json.Marshal(ar)
+		ctx, cancel := requestContext(req)
+		defer cancel()
+
+		violations, err := client.GetViolations(ctx, ar.Request)
 
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("unable to encode response"))
+			writeResponse(w, ar, &v1beta1.AdmissionResponse{
+				UID:     ar.Request.UID,
+				Allowed: false,
+				Result: &v1.Status{
+					Message: err.Error(),
+				},
+			})
+			return
+		}
+
+		if len(violations) > 0 {
+			writeResponse(w, ar, &v1beta1.AdmissionResponse{
+				UID:     ar.Request.UID,
+				Allowed: false,
+				Result: &v1.Status{
+					Status:  v1.StatusFailure,
+					Reason:  v1.StatusReasonForbidden,
+					Code:    http.StatusForbidden,
+					Message: strings.Join(violations, "; "),
+				},
+			})
 			return
 		}
 
-		w.Write(resp)
+		writeResponse(w, ar, &v1beta1.AdmissionResponse{
+			UID:     ar.Request.UID,
+			Allowed: true,
+		})
+	}
+}
+
+// decodeReview decodes the AdmissionReview of the request and writes an error response when it can't be handled.
+// The admission.k8s.io/v1 AdmissionReview is identical to v1beta1 on the wire, so both are decoded into the v1beta1 type.
+func decodeReview(w http.ResponseWriter, req *http.Request) (*v1beta1.AdmissionReview, bool) {
+	var ar v1beta1.AdmissionReview
+	if err := json.NewDecoder(req.Body).Decode(&ar); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to decode body"))
+		return nil, false
+	}
+
+	switch ar.APIVersion {
+	case V1, V1beta1:
+	case "":
+		// Requests without type information predate admission.k8s.io/v1.
+		ar.APIVersion = V1beta1
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unsupported AdmissionReview version: " + ar.APIVersion))
+		return nil, false
+	}
+
+	if ar.Request == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing admission request"))
+		return nil, false
+	}
+
+	return &ar, true
+}
+
+// writeResponse writes the response as an AdmissionReview of the same version as the request.
+func writeResponse(w http.ResponseWriter, ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) {
+	review := v1beta1.AdmissionReview{
+		Response: response,
+	}
+	review.APIVersion = ar.APIVersion
+	review.Kind = admissionReviewKind
+
+	resp, err := json.Marshal(review)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to encode response"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
 
+// requestContext returns the context of the request bound to the timeout the API server passes as query parameter,
+// so resolution stops once the API server gave up on the webhook.
+func requestContext(req *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := time.ParseDuration(req.URL.Query().Get("timeout"))
+	if err != nil || timeout <= 0 {
+		return context.WithCancel(req.Context())
 	}
+	return context.WithTimeout(req.Context(), timeout)
 }
@@ -0,0 +1,180 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jw-s/updatey/pkg/k8s"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+)
+
+type testClient struct {
+	k8s.Interface
+	patches    []*k8s.JSONPatch
+	violations []string
+	err        error
+}
+
+func (c *testClient) GetPatches(ctx context.Context, ar *v1beta1.AdmissionRequest) ([]*k8s.JSONPatch, error) {
+	return c.patches, c.err
+}
+
+func (c *testClient) GetViolations(ctx context.Context, ar *v1beta1.AdmissionRequest) ([]string, error) {
+	return c.violations, c.err
+}
+
+func TestAdmitHandler(t *testing.T) {
+	patches := []*k8s.JSONPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/containers/0/image",
+			Value: "nginx:1.14.2",
+		},
+	}
+
+	tests := []struct {
+		body               string
+		client             *testClient
+		expectedStatus     int
+		expectedAPIVersion string
+		expectedAllowed    bool
+		expectedPatch      string
+	}{
+		{
+			body:               `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:             &testClient{patches: patches},
+			expectedStatus:     http.StatusOK,
+			expectedAPIVersion: V1,
+			expectedAllowed:    true,
+			expectedPatch:      `[{"op":"replace","path":"/spec/containers/0/image","value":"nginx:1.14.2"}]`,
+		},
+		{
+			body:               `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:             &testClient{patches: patches},
+			expectedStatus:     http.StatusOK,
+			expectedAPIVersion: V1beta1,
+			expectedAllowed:    true,
+			expectedPatch:      `[{"op":"replace","path":"/spec/containers/0/image","value":"nginx:1.14.2"}]`,
+		},
+		{
+			body:               `{"request":{"uid":"1"}}`,
+			client:             &testClient{patches: patches},
+			expectedStatus:     http.StatusOK,
+			expectedAPIVersion: V1beta1,
+			expectedAllowed:    true,
+			expectedPatch:      `[{"op":"replace","path":"/spec/containers/0/image","value":"nginx:1.14.2"}]`,
+		},
+		{
+			body:               `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:             &testClient{err: errors.New("failed")},
+			expectedStatus:     http.StatusOK,
+			expectedAPIVersion: V1,
+			expectedAllowed:    false,
+		},
+		{
+			body:           `{"apiVersion":"admission.k8s.io/v2","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:         &testClient{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			body:           `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			client:         &testClient{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			body:           `{`,
+			client:         &testClient{},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		AdmitHandler(test.client).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admit?timeout=10s", strings.NewReader(test.body)))
+
+		assert.Equal(t, test.expectedStatus, recorder.Code, test.body)
+		if test.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var review v1beta1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, test.expectedAPIVersion, review.APIVersion)
+		assert.Equal(t, "AdmissionReview", review.Kind)
+		assert.Equal(t, "1", string(review.Response.UID))
+		assert.Equal(t, test.expectedAllowed, review.Response.Allowed)
+		if test.expectedPatch != "" {
+			assert.JSONEq(t, test.expectedPatch, string(review.Response.Patch))
+			assert.Equal(t, v1beta1.PatchTypeJSONPatch, *review.Response.PatchType)
+		}
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	tests := []struct {
+		body            string
+		client          *testClient
+		expectedStatus  int
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{
+			body:            `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:          &testClient{},
+			expectedStatus:  http.StatusOK,
+			expectedAllowed: true,
+		},
+		{
+			body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client: &testClient{
+				violations: []string{
+					`container "nginx": image "nginx:latest" uses the latest tag`,
+					`container "sidecar": image "envoy" implicitly uses the latest tag`,
+				},
+			},
+			expectedStatus:  http.StatusOK,
+			expectedMessage: `container "nginx": image "nginx:latest" uses the latest tag; container "sidecar": image "envoy" implicitly uses the latest tag`,
+		},
+		{
+			body:            `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"1"}}`,
+			client:          &testClient{err: errors.New("failed")},
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "failed",
+		},
+		{
+			body:           `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			client:         &testClient{},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ValidateHandler(test.client).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(test.body)))
+
+		assert.Equal(t, test.expectedStatus, recorder.Code, test.body)
+		if test.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var review v1beta1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, test.expectedAllowed, review.Response.Allowed)
+		assert.Nil(t, review.Response.Patch)
+		if test.expectedMessage != "" {
+			assert.Equal(t, test.expectedMessage, review.Response.Result.Message)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+var (
+	_ Interface         = &CachedClient{}
+	_ PagedInterface    = &CachedClient{}
+	_ PlatformInterface = &CachedClient{}
+	_ CreatedInterface  = &CachedClient{}
+)
+
+// CacheStats holds counters about the lookups served by a CachedClient.
+type CacheStats struct {
+	// Hits counts lookups served from a fresh cache entry or by joining a lookup already in flight.
+	Hits uint64
+	// Misses counts lookups which were passed on to the underlying client.
+	Misses uint64
+	// Stale counts lookups which failed and were served from an expired cache entry instead.
+	Stale uint64
+}
+
+// maxStaleness is how long expired tags are kept to be served when the registry can't be reached, they are deleted afterwards.
+const maxStaleness = time.Hour
+
+// CachedClient is an Interface which caches the tags of repositories for a limited time.
+// Concurrent lookups of the same repository with the same credentials are deduplicated
+// and expired tags are served when the registry can't be reached.
+type CachedClient struct {
+	// Timeout bounds lookups shared by concurrent callers, which don't end with the context of any single caller. Defaults to 5 minutes.
+	Timeout time.Duration
+
+	client Interface
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	inFlight  map[string]*lookup
+	lastSweep time.Time
+
+	hits   uint64
+	misses uint64
+	stale  uint64
+}
+
+type cacheEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+type lookup struct {
+	done chan struct{}
+	tags []string
+	err  error
+	// partial reports that listing was stopped by the caller which started the lookup before every tag was listed.
+	partial bool
+}
+
+// NewCachedClient returns a CachedClient which caches the tags retrieved by the client for the ttl.
+func NewCachedClient(client Interface, ttl time.Duration) *CachedClient {
+	return &CachedClient{
+		client:   client,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  map[string]*cacheEntry{},
+		inFlight: map[string]*lookup{},
+	}
+}
+
+// Tags retrieves docker tags for a specific repository from the cache or the underlying client.
+// Lookups are shared by concurrent callers and run on their own context, every caller stops waiting for them when its context is done.
+func (c *CachedClient) Tags(ctx context.Context, authentication *Auth, repository string) ([]string, error) {
+	return c.tags(ctx, authentication, repository, nil)
+}
+
+// tags serves the tags from the cache or joins or starts a lookup, a nil done lists every tag.
+// Callers joining a lookup which was stopped before listing the tags they need start a lookup of every tag instead.
+func (c *CachedClient) tags(ctx context.Context, authentication *Auth, repository string, done func(tags []string) bool) ([]string, error) {
+	key, err := cacheKey(authentication, repository)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sweep()
+	entry, cached := c.entries[key]
+	if cached && c.now().Before(entry.expires) {
+		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return copyTags(entry.tags), nil
+	}
+
+	l, exists := c.inFlight[key]
+	if exists {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		l = &lookup{done: make(chan struct{})}
+		c.inFlight[key] = l
+		atomic.AddUint64(&c.misses, 1)
+		go c.fetch(ctx, key, l, entry, authentication, repository, done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if l.err == nil && l.partial && (done == nil || !done(l.tags)) {
+		return c.tags(ctx, authentication, repository, nil)
+	}
+	return copyTags(l.tags), l.err
+}
+
+// fetch retrieves the tags of a lookup from the underlying client, falling back to the expired entry when it fails.
+// Paged clients stop listing once done reports that the tags listed so far suffice, such partial lists are returned but aren't cached.
+// The lookup is detached from the context of the caller which started it, so cancelling that caller doesn't fail the others.
+func (c *CachedClient) fetch(ctx context.Context, key string, l *lookup, expired *cacheEntry, authentication *Auth, repository string, done func(tags []string) bool) {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, c.timeout())
+	defer cancel()
+
+	if pagedClient, paged := c.client.(PagedInterface); paged && done != nil {
+		l.tags, l.err = pagedClient.TagsUntil(ctx, authentication, repository, func(tags []string) bool {
+			l.partial = done(tags)
+			return l.partial
+		})
+	} else {
+		l.tags, l.err = c.client.Tags(ctx, authentication, repository)
+	}
+
+	c.mu.Lock()
+	if l.err == nil && !l.partial {
+		c.entries[key] = &cacheEntry{
+			tags:    l.tags,
+			expires: c.now().Add(c.ttl),
+		}
+	} else if l.err != nil && expired != nil {
+		atomic.AddUint64(&c.stale, 1)
+		l.tags, l.err, l.partial = expired.tags, nil, false
+	}
+	delete(c.inFlight, key)
+	c.mu.Unlock()
+	close(l.done)
+}
+
+func (c *CachedClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultTimeOut
+}
+
+// sweep deletes the entries which expired too long ago to be served, at most once per ttl. The lock has to be held.
+func (c *CachedClient) sweep() {
+	now := c.now()
+
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for key, entry := range c.entries {
+		if now.After(entry.expires.Add(maxStaleness)) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// TagsUntil retrieves docker tags for a specific repository from the cache or page by page from the underlying client.
+// Only complete tag lists are cached, lists which were cut short by done aren't. Lookups are shared by concurrent callers like Tags,
+// callers joining a lookup which was cut short before listing the tags they need list every tag instead.
+func (c *CachedClient) TagsUntil(ctx context.Context, authentication *Auth, repository string, done func(tags []string) bool) ([]string, error) {
+	return c.tags(ctx, authentication, repository, done)
+}
+
+// Digest retrieves the content digest of a tag from the underlying client, as tags are mutable it isn't cached.
+func (c *CachedClient) Digest(ctx context.Context, authentication *Auth, repository, tag string) (string, error) {
+	return c.client.Digest(ctx, authentication, repository, tag)
+}
+
+// Platforms retrieves the platforms a tag is published for from the underlying client, as tags are mutable it isn't cached.
+func (c *CachedClient) Platforms(ctx context.Context, authentication *Auth, repository, tag string) ([]Platform, error) {
+	platformClient, ok := c.client.(PlatformInterface)
+
+	if !ok {
+		return nil, fmt.Errorf("%T can't inspect platforms", c.client)
+	}
+
+	return platformClient.Platforms(ctx, authentication, repository, tag)
+}
+
+// Created retrieves the creation time of the image a tag points to from the underlying client, which caches it by digest.
+func (c *CachedClient) Created(ctx context.Context, authentication *Auth, repository, tag string) (time.Time, error) {
+	createdClient, ok := c.client.(CreatedInterface)
+
+	if !ok {
+		return time.Time{}, fmt.Errorf("%T can't inspect creation times", c.client)
+	}
+
+	return createdClient.Created(ctx, authentication, repository, tag)
+}
+
+// Stats returns the current counters of the cache.
+func (c *CachedClient) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Stale:  atomic.LoadUint64(&c.stale),
+	}
+}
+
+// cacheKey identifies a repository on a registry as seen with the given credentials, without retaining the credentials themselves.
+func cacheKey(authentication *Auth, repository string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(repository)
+
+	if err != nil {
+		return "", err
+	}
+
+	return named.Name() + "|" + credentialIdentity(authentication), nil
+}
+
+// credentialIdentity identifies credentials without retaining them, anonymous access is identified by an empty string.
+func credentialIdentity(authentication *Auth) string {
+	if authentication == nil || (authentication.Username == "" && authentication.Password == "" && authentication.IdentityToken == "") {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(authentication.Username + "\x00" + authentication.Password + "\x00" + authentication.IdentityToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// detachedContext carries the values of its parent without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+func copyTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	return append([]string(nil), tags...)
+}
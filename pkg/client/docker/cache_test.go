@@ -0,0 +1,298 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClient struct {
+	calls   int32
+	tags    []string
+	err     error
+	release chan struct{}
+}
+
+func (c *testClient) Tags(ctx context.Context, auth *Auth, repository string) ([]string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	return c.tags, c.err
+}
+
+func (c *testClient) Digest(ctx context.Context, auth *Auth, repository, tag string) (string, error) {
+	return "sha256:digest", nil
+}
+
+func TestCachedClientTTL(t *testing.T) {
+	now := time.Now()
+	client := &testClient{tags: []string{"1.0"}}
+	cache := NewCachedClient(client, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		tags, err := cache.Tags(context.Background(), nil, "alpine")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1.0"}, tags)
+	}
+	assert.Equal(t, int32(1), client.calls)
+
+	// The normalized name shares the cache entry.
+	_, err := cache.Tags(context.Background(), nil, "docker.io/library/alpine")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), client.calls)
+
+	now = now.Add(2 * time.Minute)
+	client.tags = []string{"1.0", "1.1"}
+
+	tags, err := cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1"}, tags)
+	assert.Equal(t, int32(2), client.calls)
+
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 2}, cache.Stats())
+}
+
+func TestCachedClientCredentials(t *testing.T) {
+	client := &testClient{tags: []string{"1.0"}}
+	cache := NewCachedClient(client, time.Minute)
+
+	for _, auth := range []*Auth{nil, {}, {Username: "a", Password: "b"}, {Username: "a", Password: "c"}, {Username: "a", Password: "b"}} {
+		_, err := cache.Tags(context.Background(), auth, "alpine")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(3), client.calls)
+}
+
+func TestCachedClientStale(t *testing.T) {
+	now := time.Now()
+	client := &testClient{tags: []string{"1.0"}}
+	cache := NewCachedClient(client, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	client.tags, client.err = nil, errors.New("registry unavailable")
+
+	tags, err := cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0"}, tags)
+
+	// Nothing cached to fall back to.
+	_, err = cache.Tags(context.Background(), nil, "nginx")
+	assert.Error(t, err)
+
+	assert.Equal(t, CacheStats{Misses: 3, Stale: 1}, cache.Stats())
+}
+
+func TestCachedClientDeduplicates(t *testing.T) {
+	client := &testClient{tags: []string{"1.0"}, release: make(chan struct{})}
+	cache := NewCachedClient(client, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tags, err := cache.Tags(context.Background(), nil, "alpine")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1.0"}, tags)
+		}()
+	}
+
+	for atomic.LoadInt32(&client.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), client.calls)
+	assert.Equal(t, uint64(1), cache.Stats().Misses)
+	assert.Equal(t, uint64(9), cache.Stats().Hits)
+}
+
+func TestCachedClientCancelledLeader(t *testing.T) {
+	client := &testClient{tags: []string{"1.0"}, release: make(chan struct{})}
+	cache := NewCachedClient(client, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	leader := make(chan error)
+	go func() {
+		_, err := cache.Tags(ctx, nil, "alpine")
+		leader <- err
+	}()
+
+	for atomic.LoadInt32(&client.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waiter := make(chan []string)
+	go func() {
+		tags, err := cache.Tags(context.Background(), nil, "alpine")
+		assert.NoError(t, err)
+		waiter <- tags
+	}()
+
+	// The caller which started the lookup gives up, the lookup itself continues for the others.
+	cancel()
+	assert.Equal(t, context.Canceled, <-leader)
+
+	close(client.release)
+	assert.Equal(t, []string{"1.0"}, <-waiter)
+	assert.Equal(t, int32(1), client.calls)
+}
+
+func TestCachedClientEviction(t *testing.T) {
+	now := time.Now()
+	client := &testClient{tags: []string{"1.0"}}
+	cache := NewCachedClient(client, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for _, repository := range []string{"alpine", "nginx"} {
+		_, err := cache.Tags(context.Background(), nil, repository)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, cache.entries, 2)
+
+	// Expired entries are kept to be served while the registry is down.
+	now = now.Add(30 * time.Minute)
+	_, err := cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+	assert.Len(t, cache.entries, 2)
+
+	// Entries which expired too long ago are deleted, even when their repository isn't looked up again.
+	now = now.Add(maxStaleness)
+	_, err = cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+	assert.Len(t, cache.entries, 1)
+	assert.Contains(t, cache.entries, "docker.io/library/alpine|")
+}
+
+func TestCachedClientInvalidRepository(t *testing.T) {
+	client := &testClient{}
+	cache := NewCachedClient(client, time.Minute)
+
+	_, err := cache.Tags(context.Background(), nil, "INVALID")
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), client.calls)
+}
+
+type testPagedClient struct {
+	testClient
+	pages [][]string
+}
+
+func (c *testPagedClient) Tags(ctx context.Context, auth *Auth, repository string) ([]string, error) {
+	return c.TagsUntil(ctx, auth, repository, nil)
+}
+
+func (c *testPagedClient) TagsUntil(ctx context.Context, auth *Auth, repository string, done func(tags []string) bool) ([]string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+
+	var tags []string
+	for _, page := range c.pages {
+		tags = append(tags, page...)
+		if done != nil && done(tags) {
+			break
+		}
+	}
+	return tags, c.err
+}
+
+func TestCachedClientTagsUntil(t *testing.T) {
+	client := &testPagedClient{pages: [][]string{{"1.0", "1.1"}, {"1.2"}}}
+	cache := NewCachedClient(client, time.Minute)
+
+	stopEarly := func(tags []string) bool { return len(tags) >= 2 }
+	never := func(tags []string) bool { return false }
+
+	// Lists which were cut short aren't cached.
+	tags, err := cache.TagsUntil(context.Background(), nil, "alpine", stopEarly)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1"}, tags)
+
+	tags, err = cache.TagsUntil(context.Background(), nil, "alpine", never)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1", "1.2"}, tags)
+	assert.Equal(t, int32(2), client.calls)
+
+	// Complete lists are.
+	tags, err = cache.TagsUntil(context.Background(), nil, "alpine", stopEarly)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1", "1.2"}, tags)
+	assert.Equal(t, int32(2), client.calls)
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+}
+
+func TestCachedClientTagsUntilDeduplicates(t *testing.T) {
+	client := &testPagedClient{testClient: testClient{release: make(chan struct{})}, pages: [][]string{{"1.0", "1.1"}, {"1.2"}}}
+	cache := NewCachedClient(client, time.Minute)
+
+	stopEarly := func(tags []string) bool { return len(tags) >= 2 }
+	never := func(tags []string) bool { return false }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tags, err := cache.TagsUntil(context.Background(), nil, "alpine", stopEarly)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1.0", "1.1"}, tags)
+		}()
+	}
+
+	for atomic.LoadInt32(&client.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Callers needing every tag don't settle for a lookup which was cut short.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tags, err := cache.TagsUntil(context.Background(), nil, "alpine", never)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1.0", "1.1", "1.2"}, tags)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(2), client.calls)
+}
+
+func TestCachedClientTagsUntilExpired(t *testing.T) {
+	now := time.Now()
+	client := &testPagedClient{pages: [][]string{{"1.0", "1.1"}, {"1.2"}}}
+	cache := NewCachedClient(client, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.Tags(context.Background(), nil, "alpine")
+	assert.NoError(t, err)
+
+	// A listing cut short after the entry expired is fresher than the expired entry.
+	now = now.Add(2 * time.Minute)
+	client.pages = [][]string{{"1.3"}, {"1.0", "1.1", "1.2"}}
+
+	tags, err := cache.TagsUntil(context.Background(), nil, "alpine", func(tags []string) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.3"}, tags)
+
+	assert.Equal(t, CacheStats{Misses: 2}, cache.Stats())
+}
@@ -0,0 +1,161 @@
+package docker
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAliases are the aliases of the docker hub registry in the order their configuration is looked up, "docker.io" first.
+var dockerHubAliases = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// dockerHubHosts are the aliases of the docker hub registry, they're all treated as "docker.io".
+var dockerHubHosts = func() map[string]bool {
+	hosts := map[string]bool{}
+	for _, alias := range dockerHubAliases {
+		hosts[alias] = true
+	}
+	return hosts
+}()
+
+// AuthConfig holds the credentials of a registry as stored in docker config files.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// Config maps registries to their credentials like the auths of a docker config file or a .dockercfg file.
+// Registries may be given with a scheme and path, e.g. "https://index.docker.io/v1/", or use wildcard hosts like "*.azurecr.io".
+type Config map[string]AuthConfig
+
+// Lookup returns the credentials of the registry matching the image, following the rules of the kubelet.
+// When several registries match, the one with the longest path is used, e.g. "registry.local/team" before "registry.local".
+func (c Config) Lookup(ref *Reference) (*Auth, error) {
+	target, err := parseRegistryURL(ref.Name())
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bestKey string
+	var best *url.URL
+
+	for key := range c {
+		registry, err := parseRegistryURL(key)
+		if err != nil || !registryMatches(registry, target) {
+			continue
+		}
+
+		if best == nil || moreSpecific(registry, key, best, bestKey) {
+			best, bestKey = registry, key
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("registry domain: %s does not exist", ref.Domain())
+	}
+
+	return c[bestKey].credentials()
+}
+
+// credentials returns the credentials, the auth field takes precedence over the username and password.
+func (a AuthConfig) credentials() (*Auth, error) {
+	authentication := &Auth{
+		Username:      a.Username,
+		Password:      a.Password,
+		IdentityToken: a.IdentityToken,
+	}
+
+	if a.Auth != "" {
+		b, err := base64.StdEncoding.DecodeString(a.Auth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Passwords may contain colons, usernames may not.
+		basicAuth := strings.SplitN(string(b), ":", 2)
+
+		if len(basicAuth) != 2 {
+			return nil, errors.New("auth field should equal basic auth syntax")
+		}
+
+		authentication.Username, authentication.Password = basicAuth[0], basicAuth[1]
+	}
+
+	if authentication.Username == "" && authentication.Password == "" && authentication.IdentityToken == "" {
+		return nil, errors.New("no credentials found for registry")
+	}
+
+	return authentication, nil
+}
+
+// parseRegistryURL parses a registry which may lack a scheme, aliases of docker hub and their api version paths are normalized.
+func parseRegistryURL(registry string) (*url.URL, error) {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+
+	u, err := url.Parse(registry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("registry %q has no host", registry)
+	}
+
+	if dockerHubHosts[u.Hostname()] {
+		u.Host = "docker.io"
+		if path := strings.Trim(u.Path, "/"); path == "v1" || path == "v2" {
+			u.Path = ""
+		}
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	return u, nil
+}
+
+// registryMatches reports whether the registry, whose host may contain wildcards, covers the target.
+func registryMatches(registry, target *url.URL) bool {
+	if registry.Port() != target.Port() {
+		return false
+	}
+
+	registryParts := strings.Split(registry.Hostname(), ".")
+	targetParts := strings.Split(target.Hostname(), ".")
+
+	if len(registryParts) != len(targetParts) {
+		return false
+	}
+
+	for i := range registryParts {
+		if matched, err := filepath.Match(registryParts[i], targetParts[i]); err != nil || !matched {
+			return false
+		}
+	}
+
+	return registry.Path == "" || target.Path == registry.Path || strings.HasPrefix(target.Path, registry.Path+"/")
+}
+
+// moreSpecific reports whether a registry is more specific than another one matching the same target.
+// Longer paths win over shorter ones and exact hosts over wildcards, the key breaks ties to keep the lookup deterministic.
+func moreSpecific(registry *url.URL, key string, other *url.URL, otherKey string) bool {
+	if len(registry.Path) != len(other.Path) {
+		return len(registry.Path) > len(other.Path)
+	}
+
+	wildcard, otherWildcard := strings.Contains(registry.Host, "*"), strings.Contains(other.Host, "*")
+	if wildcard != otherWildcard {
+		return otherWildcard
+	}
+
+	return key < otherKey
+}
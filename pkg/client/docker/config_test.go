@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigLookup(t *testing.T) {
+	tests := []struct {
+		config   Config
+		image    string
+		expected *Auth
+		err      bool
+	}{
+		{
+			config: Config{
+				"https://index.docker.io/v1/": {Username: "hub", Password: "secret"},
+			},
+			image:    "nginx:~1.14",
+			expected: &Auth{Username: "hub", Password: "secret"},
+		},
+		{
+			config: Config{
+				"registry-1.docker.io": {Username: "hub", Password: "secret"},
+			},
+			image:    "docker.io/team/app",
+			expected: &Auth{Username: "hub", Password: "secret"},
+		},
+		{
+			config: Config{
+				"http://registry.local:5000": {Username: "local", Password: "secret"},
+			},
+			image:    "registry.local:5000/app:^1.2",
+			expected: &Auth{Username: "local", Password: "secret"},
+		},
+		{
+			config: Config{
+				"registry.local": {Username: "local", Password: "secret"},
+			},
+			image: "registry.local:5000/app:^1.2",
+			err:   true,
+		},
+		{
+			config: Config{
+				"*.azurecr.io": {Username: "azure", Password: "secret"},
+			},
+			image:    "team.azurecr.io/app",
+			expected: &Auth{Username: "azure", Password: "secret"},
+		},
+		{
+			config: Config{
+				"*.azurecr.io": {Username: "azure", Password: "secret"},
+			},
+			image: "azurecr.io/app",
+			err:   true,
+		},
+		{
+			config: Config{
+				"*.azurecr.io":    {Username: "wildcard", Password: "secret"},
+				"team.azurecr.io": {Username: "team", Password: "secret"},
+			},
+			image:    "team.azurecr.io/app",
+			expected: &Auth{Username: "team", Password: "secret"},
+		},
+		{
+			config: Config{
+				"quay.io":           {Username: "quay", Password: "secret"},
+				"quay.io/team":      {Username: "team", Password: "secret"},
+				"quay.io/team/app":  {Username: "app", Password: "secret"},
+				"quay.io/teamother": {Username: "other", Password: "secret"},
+			},
+			image:    "quay.io/team/app:1.0",
+			expected: &Auth{Username: "app", Password: "secret"},
+		},
+		{
+			config: Config{
+				"quay.io":           {Username: "quay", Password: "secret"},
+				"quay.io/team":      {Username: "team", Password: "secret"},
+				"quay.io/teamother": {Username: "other", Password: "secret"},
+			},
+			image:    "quay.io/team/other:1.0",
+			expected: &Auth{Username: "team", Password: "secret"},
+		},
+		{
+			config: Config{
+				"quay.io/team": {Username: "team", Password: "secret"},
+			},
+			image: "quay.io/teamother/app:1.0",
+			err:   true,
+		},
+		{
+			config: Config{
+				"quay.io": {Auth: "dXNlcjpwYTpzczp3b3Jk"}, // user:pa:ss:word
+			},
+			image:    "quay.io/app",
+			expected: &Auth{Username: "user", Password: "pa:ss:word"},
+		},
+		{
+			config: Config{
+				"quay.io": {Username: "ignored", Password: "ignored", Auth: "dXNlcjpwYXNz"}, // user:pass
+			},
+			image:    "quay.io/app",
+			expected: &Auth{Username: "user", Password: "pass"},
+		},
+		{
+			config: Config{
+				"myregistry.azurecr.io": {Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "token"},
+			},
+			image:    "myregistry.azurecr.io/app",
+			expected: &Auth{Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "token"},
+		},
+		{
+			config: Config{
+				"quay.io": {},
+			},
+			image: "quay.io/app",
+			err:   true,
+		},
+		{
+			config: Config{
+				"quay.io": {Auth: "not base64"},
+			},
+			image: "quay.io/app",
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authentication, err := test.config.Lookup(ref)
+
+		assert.Equal(t, test.err, err != nil, test.image)
+		assert.Equal(t, test.expected, authentication, test.image)
+	}
+}
@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// maxCreatedEntries bounds the creation times cached by a client, the cache starts over once it is full.
+const maxCreatedEntries = 10000
+
+// CreatedInterface is implemented by clients which can tell when the image a tag points to was created.
+type CreatedInterface interface {
+	Created(ctx context.Context, auth *Auth, repository, tag string) (time.Time, error)
+}
+
+var _ CreatedInterface = &Client{}
+
+// Created retrieves the creation time recorded in the configuration of the image a tag of a specific repository points to,
+// images of manifest lists and OCI indexes are built together, so the first platform's image is used for them.
+// Creation times are cached by the digest of the manifest, as the content it refers to can't change.
+func (c *Client) Created(ctx context.Context, authentication *Auth, repository, tag string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var created time.Time
+
+	err := c.withRepository(ctx, authentication, repository, func(repo *registryRepository) error {
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+
+		var dgst digest.Digest
+		m, err := manifests.Get(ctx, "", distribution.WithTag(tag), client.ReturnContentDigest(&dgst))
+		if err != nil {
+			return err
+		}
+
+		if dgst == "" {
+			_, payload, err := m.Payload()
+			if err != nil {
+				return err
+			}
+			dgst = digest.FromBytes(payload)
+		}
+
+		c.mu.Lock()
+		cached, exists := c.created[dgst]
+		c.mu.Unlock()
+
+		if exists {
+			created = cached
+			return nil
+		}
+
+		if created, err = manifestCreated(ctx, repo, manifests, m); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if c.created == nil || len(c.created) >= maxCreatedEntries {
+			c.created = map[digest.Digest]time.Time{}
+		}
+		c.created[dgst] = created
+		c.mu.Unlock()
+
+		return nil
+	})
+
+	return created, err
+}
+
+// manifestCreated returns the creation time of the image of a manifest.
+func manifestCreated(ctx context.Context, repo distribution.Repository, manifests distribution.ManifestService, m distribution.Manifest) (time.Time, error) {
+	switch m := m.(type) {
+	case *manifestlist.DeserializedManifestList:
+		for _, descriptor := range m.Manifests {
+			if descriptor.Platform.OS == "unknown" || descriptor.Platform.Architecture == "unknown" {
+				continue
+			}
+
+			platformManifest, err := manifests.Get(ctx, descriptor.Digest)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return manifestCreated(ctx, repo, manifests, platformManifest)
+		}
+		return time.Time{}, errors.New("manifest list without images")
+	case *schema2.DeserializedManifest:
+		return configCreated(ctx, repo, m.Config)
+	case *ocischema.DeserializedManifest:
+		return configCreated(ctx, repo, m.Config)
+	default:
+		return time.Time{}, fmt.Errorf("unsupported manifest type %T", m)
+	}
+}
+
+// configCreated returns the creation time recorded in the configuration of an image.
+func configCreated(ctx context.Context, repo distribution.Repository, config distribution.Descriptor) (time.Time, error) {
+	b, err := repo.Blobs(ctx).Get(ctx, config.Digest)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var image struct {
+		Created *time.Time `json:"created"`
+	}
+
+	if err := json.Unmarshal(b, &image); err != nil {
+		return time.Time{}, fmt.Errorf("invalid image configuration %s: %v", config.Digest, err)
+	}
+
+	if image.Created == nil {
+		return time.Time{}, fmt.Errorf("image configuration %s has no creation time", config.Digest)
+	}
+
+	return *image.Created, nil
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCreated(t *testing.T) {
+	config := `{"architecture":"amd64","os":"linux","created":"2019-03-01T10:00:00Z"}`
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(config)))
+
+	image := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",
+		"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":%q,"size":%d},"layers":[]}`, configDigest, len(config))
+	imageDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(image)))
+
+	list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[
+		{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":"sha256:3333333333333333333333333333333333333333333333333333333333333333","size":1,"platform":{"architecture":"unknown","os":"unknown"}},
+		{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":%q,"size":%d,"platform":{"architecture":"amd64","os":"linux"}}]}`, imageDigest, len(image))
+
+	manifests := map[string]struct {
+		mediaType string
+		body      string
+	}{
+		"single":    {mediaType: "application/vnd.docker.distribution.manifest.v2+json", body: image},
+		"alias":     {mediaType: "application/vnd.docker.distribution.manifest.v2+json", body: image},
+		imageDigest: {mediaType: "application/vnd.docker.distribution.manifest.v2+json", body: image},
+		"list":      {mediaType: "application/vnd.docker.distribution.manifest.list.v2+json", body: list},
+	}
+
+	var configRequests int32
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case req.URL.Path == "/v2/app/blobs/"+configDigest:
+			atomic.AddInt32(&configRequests, 1)
+			w.Write([]byte(config))
+		case strings.HasPrefix(req.URL.Path, "/v2/app/manifests/"):
+			m, exists := manifests[strings.TrimPrefix(req.URL.Path, "/v2/app/manifests/")]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", m.mediaType)
+			w.Write([]byte(m.body))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "https://") + "/app"
+	authentication := &Auth{Transport: server.Client().Transport}
+	expected := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tag            string
+		err            bool
+		configRequests int32
+	}{
+		{tag: "single", configRequests: 1},
+		// Tags pointing to the same manifest share the cached creation time.
+		{tag: "alias", configRequests: 1},
+		{tag: "list", configRequests: 2},
+		{tag: "list", configRequests: 2},
+		{tag: "missing", err: true, configRequests: 2},
+	}
+
+	client := &Client{}
+
+	for _, test := range tests {
+		created, err := client.Created(context.Background(), authentication, repository, test.tag)
+
+		assert.Equal(t, test.err, err != nil, "%s: %v", test.tag, err)
+		if !test.err {
+			assert.True(t, expected.Equal(created), "%s: %s", test.tag, created)
+		}
+		assert.Equal(t, test.configRequests, atomic.LoadInt32(&configRequests), test.tag)
+	}
+}
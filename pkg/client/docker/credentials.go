@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// dockerHubServerAddress is how docker refers to docker hub when talking to credential helpers.
+	dockerHubServerAddress = "https://index.docker.io/v1/"
+	// credentialHelperPrefix prefixes the name of every credential helper executable.
+	credentialHelperPrefix = "docker-credential-"
+	// identityTokenUsername is returned by credential helpers when the secret is an identity token.
+	identityTokenUsername = "<token>"
+)
+
+// CredentialSource provides credentials for registries independently of image pull secrets, e.g. the credentials of a node.
+type CredentialSource interface {
+	// Credentials returns the credentials for the registry of the image and possibly an error when there are none.
+	Credentials(ctx context.Context, ref *Reference) (*Auth, error)
+}
+
+var _ CredentialSource = &ConfigFile{}
+
+// ConfigFile is a docker config.json file, credentials are looked up by credential helpers before the stored auths.
+type ConfigFile struct {
+	Auths       Config            `json:"auths,omitempty"`
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
+	CredsStore  string            `json:"credsStore,omitempty"`
+
+	path string
+}
+
+// LoadConfigFile reads a docker config.json file.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := &ConfigFile{path: path}
+
+	if err := json.Unmarshal(b, configFile); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %v", path, err)
+	}
+
+	return configFile, nil
+}
+
+// Credentials returns the credentials for the registry of the image like the docker cli does:
+// the credential helper configured for the registry is used first, followed by the credentials store and the stored auths.
+func (f *ConfigFile) Credentials(ctx context.Context, ref *Reference) (*Auth, error) {
+	domain := ref.Domain()
+
+	if helper, exists := f.CredHelpers[domain]; exists {
+		return credentialHelper(ctx, helper, serverAddress(domain))
+	}
+
+	if f.CredsStore != "" {
+		authentication, err := credentialHelper(ctx, f.CredsStore, serverAddress(domain))
+		if err == nil {
+			return authentication, nil
+		}
+		if len(f.Auths) == 0 {
+			return nil, err
+		}
+	}
+
+	return f.Auths.Lookup(ref)
+}
+
+func (f *ConfigFile) String() string {
+	return "docker config " + f.path
+}
+
+// serverAddress returns how credential helpers refer to the registry of the domain.
+func serverAddress(domain string) string {
+	if domain == "docker.io" {
+		return dockerHubServerAddress
+	}
+	return domain
+}
+
+// credentialHelper retrieves credentials from a docker-credential-* executable using the protocol described at
+// https://github.com/docker/docker-credential-helpers, the server address is passed on stdin and the credentials are read from stdout.
+func credentialHelper(ctx context.Context, helper, serverAddress string) (*Auth, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		return nil, fmt.Errorf("credential helper %s failed for %s: %v: %s", helper, serverAddress, err, message)
+	}
+
+	var credentials struct {
+		Username string
+		Secret   string
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid credentials for %s: %v", helper, serverAddress, err)
+	}
+
+	if credentials.Username == identityTokenUsername {
+		return &Auth{IdentityToken: credentials.Secret}, nil
+	}
+
+	return &Auth{
+		Username: credentials.Username,
+		Password: credentials.Secret,
+	}, nil
+}
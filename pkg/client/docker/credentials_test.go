@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withTestCredentialHelper puts the fake docker-credential-test helper of the testdata directory on the path.
+func withTestCredentialHelper(t *testing.T) func() {
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() {
+		os.Setenv("PATH", path)
+	}
+}
+
+func TestConfigFileCredentials(t *testing.T) {
+	defer withTestCredentialHelper(t)()
+
+	tests := []struct {
+		config   string
+		image    string
+		expected *Auth
+		err      bool
+	}{
+		{
+			config:   `{"credHelpers":{"docker.io":"test"}}`,
+			image:    "nginx:~1.14",
+			expected: &Auth{Username: "hub", Password: "secret"},
+		},
+		{
+			config:   `{"credHelpers":{"token.registry.local":"test"}}`,
+			image:    "token.registry.local/app",
+			expected: &Auth{IdentityToken: "identity"},
+		},
+		{
+			config: `{"credHelpers":{"quay.io":"test"},"auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`,
+			image:  "quay.io/app",
+			err:    true,
+		},
+		{
+			config: `{"credHelpers":{"quay.io":"missing"}}`,
+			image:  "quay.io/app",
+			err:    true,
+		},
+		{
+			config:   `{"credsStore":"test","auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`,
+			image:    "nginx",
+			expected: &Auth{Username: "hub", Password: "secret"},
+		},
+		{
+			// The stored auths are used when the credentials store has none.
+			config:   `{"credsStore":"test","auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`,
+			image:    "quay.io/app",
+			expected: &Auth{Username: "user", Password: "pass"},
+		},
+		{
+			config: `{"credsStore":"test"}`,
+			image:  "quay.io/app",
+			err:    true,
+		},
+		{
+			config:   `{"auths":{"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNz"}}}`,
+			image:    "docker.io/library/nginx:1.14.2",
+			expected: &Auth{Username: "user", Password: "pass"},
+		},
+	}
+
+	for _, test := range tests {
+		file, err := ioutil.TempFile("", "config.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+
+		if _, err := file.WriteString(test.config); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		configFile, err := LoadConfigFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authentication, err := configFile.Credentials(context.Background(), ref)
+
+		assert.Equal(t, test.err, err != nil, test.config)
+		assert.Equal(t, test.expected, authentication, test.config)
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	_, err := LoadConfigFile(filepath.Join("testdata", "missing.json"))
+	assert.Error(t, err)
+
+	_, err = LoadConfigFile(filepath.Join("testdata", "docker-credential-test"))
+	assert.Error(t, err)
+}
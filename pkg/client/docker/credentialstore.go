@@ -2,30 +2,53 @@ package docker
 
 import (
 	"net/url"
+	"sync"
 
 	"github.com/docker/distribution/registry/client/auth"
 )
 
 type credentialStore struct {
-	username string
-	password string
+	username      string
+	password      string
+	identityToken string
+
+	mu            sync.Mutex
+	refreshTokens map[string]string
 }
 
-// NewCredentialStore provides static username and password to the store.
-func NewCredentialStore(username, password string) auth.CredentialStore {
-	return credentialStore{
-		username: username,
-		password: password,
+// NewCredentialStore provides static username, password and identity token to the store.
+// The identity token is used as refresh token to obtain registry tokens via OAuth2 until the token server issues a refresh token of its own.
+func NewCredentialStore(username, password, identityToken string) auth.CredentialStore {
+	return &credentialStore{
+		username:      username,
+		password:      password,
+		identityToken: identityToken,
+		refreshTokens: map[string]string{},
 	}
 }
 
-func (cs credentialStore) Basic(*url.URL) (string, string) {
+func (cs *credentialStore) Basic(*url.URL) (string, string) {
 	return cs.username, cs.password
 }
 
-func (cs credentialStore) RefreshToken(*url.URL, string) string {
-	return ""
+func (cs *credentialStore) RefreshToken(realm *url.URL, service string) string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if token, exists := cs.refreshTokens[refreshTokenKey(realm, service)]; exists {
+		return token
+	}
+	return cs.identityToken
+}
+
+func (cs *credentialStore) SetRefreshToken(realm *url.URL, service, token string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.refreshTokens[refreshTokenKey(realm, service)] = token
 }
 
-func (cs credentialStore) SetRefreshToken(*url.URL, string, string) {
+// refreshTokenKey identifies the token server refresh tokens were issued by.
+func refreshTokenKey(realm *url.URL, service string) string {
+	return realm.String() + "|" + service
 }
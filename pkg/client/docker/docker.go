@@ -7,31 +7,51 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution/registry/client/auth"
+	"github.com/golang/glog"
 
+	_ "github.com/docker/distribution/manifest/manifestlist" // registers manifest list and OCI index media types
+	_ "github.com/docker/distribution/manifest/ocischema"    // registers OCI manifest media type
+	_ "github.com/docker/distribution/manifest/schema2"      // registers schema2 manifest media type
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/client"
 	"github.com/docker/distribution/registry/client/transport"
+	"github.com/opencontainers/go-digest"
 )
 
 const (
-	defaultTimeOut = time.Minute * 5
-	authClientID   = "ivm-controller"
+	defaultTimeOut     = time.Minute * 5
+	defaultPingTimeOut = time.Second * 15
+	defaultPageSize    = 1000
+	authClientID       = "ivm-controller"
 )
 
-var _ Interface = &Client{}
+var (
+	_ Interface      = &Client{}
+	_ PagedInterface = &Client{}
+)
 
 // Interface provides functionality to deal with container image tags.
 type Interface interface {
-	Tags(auth *Auth, repository string) ([]string, error)
+	Tags(ctx context.Context, auth *Auth, repository string) ([]string, error)
+	Digest(ctx context.Context, auth *Auth, repository, tag string) (string, error)
+}
+
+// PagedInterface is implemented by clients which list tags page by page and can stop once the tags listed so far suffice.
+type PagedInterface interface {
+	TagsUntil(ctx context.Context, auth *Auth, repository string, done func(tags []string) bool) ([]string, error)
 }
 
 // Auth is a helper to store authentication details for the client.
 type Auth struct {
-	Username  string
-	Password  string
+	Username string
+	Password string
+	// IdentityToken is exchanged for registry tokens instead of the username and password when set.
+	IdentityToken string
+	// Transport overrides the transport configured for the registry, defaults to http.DefaultTransport.
 	Transport http.RoundTripper
 }
 
@@ -42,72 +62,226 @@ func (c *Auth) withDefaults() {
 }
 
 // Client is the docker implemention of Interface.
-type Client struct{}
+type Client struct {
+	// Timeout limits each call to a registry, in addition to the deadline of the context. Defaults to 5 minutes.
+	Timeout time.Duration
+	// PingTimeout limits the initial ping of a registry. Defaults to 15 seconds.
+	PingTimeout time.Duration
+	// Registries configures mirrors, tls options and rate limits of registries, optional.
+	Registries *RegistryConfig
+	// PageSize is the number of tags requested per page when listing tags. Defaults to 1000.
+	PageSize int
+	// MaxTags caps the number of tags listed per repository, further tags are ignored. Tags are capped in the order the registry lists them,
+	// which is typically lexical, so the cap may hide the newest tags. Zero or negative, the default, disables the cap.
+	MaxTags int
+	// Retries is how often a request failing with a transient status like 429 or 502 is retried, zero or negative disables retries.
+	Retries int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	created  map[digest.Digest]time.Time
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultTimeOut
+}
+
+func (c *Client) pageSize() int {
+	if c.PageSize > 0 {
+		return c.PageSize
+	}
+	return defaultPageSize
+}
+
+func (c *Client) maxTags() int {
+	if c.MaxTags < 0 {
+		return 0
+	}
+	return c.MaxTags
+}
+
+func (c *Client) retries() int {
+	if c.Retries < 0 {
+		return 0
+	}
+	return c.Retries
+}
+
+func (c *Client) pingTimeout() time.Duration {
+	if c.PingTimeout > 0 {
+		return c.PingTimeout
+	}
+	return defaultPingTimeOut
+}
 
-// Tags retrieves docker tags for a specific repository.
-func (c *Client) Tags(authentication *Auth, repository string) ([]string, error) {
+// Tags retrieves docker tags for a specific repository, the mirrors of its registry are tried before the registry itself.
+func (c *Client) Tags(ctx context.Context, authentication *Auth, repository string) ([]string, error) {
+	return c.TagsUntil(ctx, authentication, repository, nil)
+}
+
+// TagsUntil retrieves docker tags for a specific repository page by page, it stops early once done reports that the tags listed so far suffice.
+func (c *Client) TagsUntil(ctx context.Context, authentication *Auth, repository string, done func(tags []string) bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var tags []string
+
+	err := c.withRepository(ctx, authentication, repository, func(repo *registryRepository) (err error) {
+		tags, err = repo.listTags(ctx, c.pageSize(), c.maxTags(), done)
+		return err
+	})
+
+	return tags, err
+}
+
+// Digest retrieves the content digest of the manifest a tag of a specific repository points to.
+func (c *Client) Digest(ctx context.Context, authentication *Auth, repository, tag string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var digest string
 
+	err := c.withRepository(ctx, authentication, repository, func(repo *registryRepository) error {
+		descriptor, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			return err
+		}
+		digest = descriptor.Digest.String()
+		return nil
+	})
+
+	return digest, err
+}
+
+// withRepository calls fn with the repository at every endpoint of its registry in turn until it succeeds.
+// The failures of every endpoint are returned when none succeeds, mirrors which already failed within the lookup of the context are skipped.
+func (c *Client) withRepository(ctx context.Context, authentication *Auth, repository string, fn func(*registryRepository) error) error {
 	if authentication == nil {
 		authentication = &Auth{}
 	}
-	authentication.withDefaults()
 
 	namedRef, err := reference.ParseNormalizedNamed(repository)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	imageName, err := reference.WithName(reference.Path(namedRef))
+	endpoints, err := c.Registries.endpoints(namedRef)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	modifiers := []transport.RequestModifier{transport.NewHeaderRequestModifier(http.Header{"User-Agent": []string{authClientID}})}
-	authTransport := transport.NewTransport(authentication.Transport, modifiers...)
+	var errs []string
 
-	registryURL, err := getRegistryURL(namedRef)
+	for _, e := range endpoints {
+		if e.mirror {
+			if err := mirrorFailure(ctx, e, repository); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", e, err))
+				continue
+			}
+		}
 
-	if err != nil {
-		return nil, err
+		endpointAuthentication := *authentication
+		if e.mirror {
+			endpointAuthentication = Auth{Transport: authentication.Transport}
+		}
+		if endpointAuthentication.Transport == nil {
+			endpointAuthentication.Transport = e.transport
+		}
+		endpointAuthentication.withDefaults()
+
+		endpointAuthentication.Transport = &retryTransport{
+			next:      endpointAuthentication.Transport,
+			limiter:   e.limiter,
+			retries:   c.retries(),
+			baseDelay: defaultRetryBaseDelay,
+			maxDelay:  defaultRetryMaxDelay,
+		}
+
+		repo, err := c.repository(ctx, &endpointAuthentication, e, namedRef)
+
+		if err == nil {
+			err = fn(repo)
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		// Tokens may have been revoked or the registry changed, the next call starts afresh.
+		c.forgetSession(&endpointAuthentication, e)
+
+		if e.mirror && ctx.Err() == nil {
+			mirrorFailed(ctx, e, repository, err)
+		}
+
+		if len(endpoints) == 1 {
+			return err
+		}
+
+		glog.V(2).Infof("%s of %s failed: %v", e, repository, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", e, err))
+
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
-	challengeManager, _, err := PingV2Registry(registryURL, authTransport)
+	return fmt.Errorf("every endpoint of %s failed: %s", repository, strings.Join(errs, "; "))
+}
+
+// repository returns the repository at the endpoint whose requests are all bound to the context.
+// The challenges and tokens negotiated with the endpoint are reused by later calls with the same credentials.
+func (c *Client) repository(ctx context.Context, authentication *Auth, e endpoint, namedRef reference.Named) (*registryRepository, error) {
+	imageName, err := e.repository(namedRef)
 
 	if err != nil {
 		return nil, err
 	}
 
-	scope := auth.RepositoryScope{
-		Repository: imageName.Name(),
-		Actions:    []string{"pull"},
-	}
-
-	creds := NewCredentialStore(authentication.Username, authentication.Password)
+	s, err := c.session(ctx, authentication, e)
 
-	tokenHandlerOptions := auth.TokenHandlerOptions{
-		Transport:   authTransport,
-		Credentials: creds,
-		Scopes:      []auth.Scope{scope},
-		ClientID:    "docker",
+	if err != nil {
+		return nil, err
 	}
-	tokenHandler := auth.NewTokenHandlerWithOptions(tokenHandlerOptions)
-	basicHandler := auth.NewBasicHandler(creds)
 
-	modifiers = append(modifiers, auth.NewAuthorizer(challengeManager, tokenHandler, basicHandler))
+	authorizer := auth.NewAuthorizer(s.challenges, contextHandler{s.tokenHandler(imageName.Name())}, auth.NewBasicHandler(s.creds))
 
-	tr := transport.NewTransport(authentication.Transport, modifiers...)
+	// The registry client doesn't pass the context on to every request, so it is bound to the transport instead.
+	tr := &contextTransport{
+		ctx:  ctx,
+		next: transport.NewTransport(authentication.Transport, userAgent(), authorizer),
+	}
 
-	repo, err := client.NewRepository(imageName, registryURL.String(), tr)
+	repo, err := client.NewRepository(imageName, e.url.String(), tr)
 
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeOut)
-	defer cancel()
-	return repo.Tags(ctx).All(ctx)
+	return &registryRepository{
+		Repository: repo,
+		name:       imageName,
+		url:        e.url,
+		client:     &http.Client{Transport: tr},
+	}, nil
+}
+
+// contextTransport binds every request without a context of its own to a context.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context() == context.Background() {
+		req = req.WithContext(t.ctx)
+	}
+	return t.next.RoundTrip(req)
 }
 
 func getRegistryURL(ref reference.Named) (*url.URL, error) {
@@ -115,26 +289,7 @@ func getRegistryURL(ref reference.Named) (*url.URL, error) {
 		if domain == "docker.io" {
 			domain = fmt.Sprintf("registry-1.%s", domain)
 		}
-		u, err := url.Parse(domain)
-		if err != nil {
-			return nil, err
-		}
-		u.Scheme = "https"
-		return u, nil
+		return &url.URL{Scheme: "https", Host: domain}, nil
 	}
 	return nil, errors.New("missing domain from image name")
 }
-
-// Split takes a string consisting of image name and tag and splits them into two.
-func Split(image string) (base string, tag string, err error) {
-	if image == "" {
-		return "", "", errors.New("image can't be empty")
-	}
-	imageParts := strings.Split(image, ":")
-
-	if len(imageParts) != 2 {
-		return "", "", errors.New("invalid image format")
-	}
-
-	return imageParts[0], imageParts[1], nil
-}
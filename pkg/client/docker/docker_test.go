@@ -0,0 +1,440 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTagsContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "https://") + "/app"
+	authentication := &Auth{Transport: server.Client().Transport}
+
+	tests := []struct {
+		client *Client
+		ctx    func() (context.Context, context.CancelFunc)
+	}{
+		{
+			client: &Client{},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+		},
+		{
+			client: &Client{Timeout: 50 * time.Millisecond},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ctx, cancel := test.ctx()
+
+		start := time.Now()
+		_, err := test.client.Tags(ctx, authentication, repository)
+		cancel()
+
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < time.Second, "took %s", time.Since(start))
+	}
+}
+
+func TestClientTagsMirrors(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/library/nginx/tags/list":
+			w.Write([]byte(`{"name":"library/nginx","tags":["upstream"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/proxy/library/nginx/tags/list":
+			if req.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"name":"proxy/library/nginx","tags":["mirror"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	unreachable := httptest.NewTLSServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	repository := strings.TrimPrefix(upstream.URL, "https://") + "/library/nginx"
+	domain := strings.TrimPrefix(upstream.URL, "https://")
+
+	tests := []struct {
+		mirrors  []string
+		expected []string
+		err      bool
+	}{
+		{
+			expected: []string{"upstream"},
+		},
+		{
+			mirrors:  []string{mirror.URL + "/proxy"},
+			expected: []string{"mirror"},
+		},
+		{
+			mirrors:  []string{unreachable.URL, mirror.URL + "/proxy/"},
+			expected: []string{"mirror"},
+		},
+		{
+			// The registry itself is used when no mirror has the repository.
+			mirrors:  []string{unreachable.URL, mirror.URL},
+			expected: []string{"upstream"},
+		},
+	}
+
+	for _, test := range tests {
+		client := &Client{
+			Registries: &RegistryConfig{
+				Registries: map[string]RegistryOptions{domain: {Mirrors: test.mirrors}},
+			},
+		}
+
+		authentication := &Auth{Username: "user", Password: "pass", Transport: upstream.Client().Transport}
+		tags, err := client.Tags(context.Background(), authentication, repository)
+
+		assert.Equal(t, test.err, err != nil, "%v: %v", test.mirrors, err)
+		assert.Equal(t, test.expected, tags, "%v", test.mirrors)
+	}
+}
+
+func TestClientTagsMirrorLookup(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/library/nginx/tags/list":
+			w.Write([]byte(`{"name":"library/nginx","tags":["upstream"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	var mu sync.Mutex
+	var listed int
+
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/library/nginx/tags/list":
+			mu.Lock()
+			listed++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	repository := strings.TrimPrefix(upstream.URL, "https://") + "/library/nginx"
+	domain := strings.TrimPrefix(upstream.URL, "https://")
+
+	tests := []struct {
+		lookup   bool
+		expected int
+	}{
+		{expected: 3},
+		{lookup: true, expected: 1},
+	}
+
+	for _, test := range tests {
+		listed = 0
+
+		client := &Client{
+			Registries: &RegistryConfig{
+				Registries: map[string]RegistryOptions{domain: {Mirrors: []string{mirror.URL}}},
+			},
+		}
+
+		ctx := context.Background()
+		if test.lookup {
+			ctx = WithLookup(ctx)
+		}
+
+		// Every credential of a lookup is tried against the mirror anonymously.
+		for _, username := range []string{"first", "second", "third"} {
+			authentication := &Auth{Username: username, Password: "pass", Transport: upstream.Client().Transport}
+			tags, err := client.Tags(ctx, authentication, repository)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"upstream"}, tags)
+		}
+
+		assert.Equal(t, test.expected, listed, "lookup %v", test.lookup)
+	}
+}
+
+func TestRegistryConfigEndpoints(t *testing.T) {
+	config := &RegistryConfig{
+		Registries: map[string]RegistryOptions{
+			"index.docker.io":     {Mirrors: []string{"harbor.internal/dockerhub-proxy", "http://mirror.local:5000"}},
+			"registry.local:5000": {Mirrors: []string{"harbor.internal/local"}},
+		},
+	}
+
+	tests := []struct {
+		config   *RegistryConfig
+		image    string
+		expected []string
+	}{
+		{
+			config:   config,
+			image:    "nginx",
+			expected: []string{"https://harbor.internal/dockerhub-proxy", "http://mirror.local:5000", "https://registry-1.docker.io"},
+		},
+		{
+			config:   config,
+			image:    "registry.local:5000/app",
+			expected: []string{"https://harbor.internal/local", "https://registry.local:5000"},
+		},
+		{
+			config:   config,
+			image:    "quay.io/app",
+			expected: []string{"https://quay.io"},
+		},
+		{
+			image:    "nginx",
+			expected: []string{"https://registry-1.docker.io"},
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		endpoints, err := test.config.endpoints(ref.named)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual []string
+		for _, e := range endpoints {
+			actual = append(actual, e.String())
+		}
+
+		assert.Equal(t, test.expected, actual, test.image)
+	}
+}
+
+func TestRegistryConfigOptionsDockerHubAliases(t *testing.T) {
+	config := &RegistryConfig{
+		Registries: map[string]RegistryOptions{
+			"registry.hub.docker.com": {Mirrors: []string{"hub.mirror"}},
+			"index.docker.io":         {Mirrors: []string{"index.mirror"}},
+			"docker.io":               {Mirrors: []string{"docker.mirror"}},
+		},
+	}
+
+	// Aliases which are configured themselves use their own options, the others always use those of docker.io.
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, []string{"docker.mirror"}, config.options("registry-1.docker.io").Mirrors)
+		assert.Equal(t, []string{"index.mirror"}, config.options("index.docker.io").Mirrors)
+	}
+
+	delete(config.Registries, "docker.io")
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, []string{"index.mirror"}, config.options("registry-1.docker.io").Mirrors)
+	}
+}
+
+func TestClientTagsTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/app/tags/list":
+			w.Write([]byte(`{"name":"app","tags":["1.0.0"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	mtlsServer := httptest.NewUnstartedServer(handler)
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	// The test servers share a certificate, which is used as client certificate as well.
+	certificate := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := writePEM(t, dir, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	cert := writePEM(t, dir, "client.cert", "CERTIFICATE", certificate.Certificate[0])
+	clientKey := writePEM(t, dir, "client.key", "PRIVATE KEY", key)
+
+	domain := strings.TrimPrefix(server.URL, "https://")
+	mtlsDomain := strings.TrimPrefix(mtlsServer.URL, "https://")
+	plainDomain := strings.TrimPrefix(plainServer.URL, "http://")
+
+	tests := []struct {
+		repository string
+		registries map[string]RegistryOptions
+		err        bool
+	}{
+		{
+			repository: domain + "/app",
+			err:        true,
+		},
+		{
+			repository: domain + "/app",
+			registries: map[string]RegistryOptions{domain: {CA: ca}},
+		},
+		{
+			repository: domain + "/app",
+			registries: map[string]RegistryOptions{domain: {InsecureSkipVerify: true}},
+		},
+		{
+			repository: mtlsDomain + "/app",
+			registries: map[string]RegistryOptions{mtlsDomain: {CA: ca}},
+			err:        true,
+		},
+		{
+			repository: mtlsDomain + "/app",
+			registries: map[string]RegistryOptions{mtlsDomain: {CA: ca, Cert: cert, Key: clientKey}},
+		},
+		{
+			repository: plainDomain + "/app",
+			err:        true,
+		},
+		{
+			repository: plainDomain + "/app",
+			registries: map[string]RegistryOptions{plainDomain: {PlainHTTP: true}},
+		},
+		{
+			// Mirrors use the tls options of their own host.
+			repository: "quay.io/app",
+			registries: map[string]RegistryOptions{
+				"quay.io": {Mirrors: []string{server.URL}},
+				domain:    {CA: ca},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		client := &Client{
+			Timeout:    5 * time.Second,
+			Registries: &RegistryConfig{Registries: test.registries},
+		}
+
+		tags, err := client.Tags(context.Background(), nil, test.repository)
+
+		assert.Equal(t, test.err, err != nil, "%s %v: %v", test.repository, test.registries, err)
+		if !test.err {
+			assert.Equal(t, []string{"1.0.0"}, tags, test.repository)
+		}
+	}
+}
+
+func TestLoadRegistryConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		config string
+		err    bool
+	}{
+		{
+			config: "registries:\n  docker.io:\n    mirrors:\n    - harbor.internal/dockerhub-proxy\n  localhost:5000:\n    plainHTTP: true\n",
+		},
+		{
+			config: "registries:\n  docker.io:\n    mirrors:\n    - ftp://harbor.internal\n",
+			err:    true,
+		},
+		{
+			config: "registries:\n  registry.local:\n    ca: " + filepath.Join(dir, "missing.crt") + "\n",
+			err:    true,
+		},
+		{
+			config: "registries:\n  registry.local:\n    cert: client.cert\n",
+			err:    true,
+		},
+		{
+			config: "registries: [",
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "registries.yaml")
+		if err := ioutil.WriteFile(path, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := LoadRegistryConfig(path)
+
+		assert.Equal(t, test.err, err != nil, "%s: %v", test.config, err)
+	}
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, b []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
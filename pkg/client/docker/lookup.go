@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"context"
+	"sync"
+)
+
+type mirrorLookupKey struct{}
+
+// mirrorLookup remembers the mirrors which failed within a single lookup. Mirrors are accessed anonymously, so a lookup trying
+// several credentials in turn would otherwise ask every failing mirror once per credential before reaching the registry.
+type mirrorLookup struct {
+	mu     sync.Mutex
+	failed map[string]error
+}
+
+// WithLookup returns a context whose calls form a single lookup, mirrors failing one of its calls are skipped by the following ones.
+func WithLookup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mirrorLookupKey{}, &mirrorLookup{failed: map[string]error{}})
+}
+
+// mirrorFailure returns how the mirror failed for the repository earlier in the lookup of the context, nil when it didn't.
+func mirrorFailure(ctx context.Context, e endpoint, repository string) error {
+	l, ok := ctx.Value(mirrorLookupKey{}).(*mirrorLookup)
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.failed[e.String()+"/"+repository]
+}
+
+// mirrorFailed records that the mirror failed for the repository within the lookup of the context.
+func mirrorFailed(ctx context.Context, e endpoint, repository string, err error) {
+	l, ok := ctx.Value(mirrorLookupKey{}).(*mirrorLookup)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failed[e.String()+"/"+repository] = err
+}
@@ -0,0 +1,126 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+)
+
+// Platform is an operating system and CPU architecture an image is published for, e.g. "linux/arm64" or "linux/arm/v7".
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// ParsePlatform parses a platform like "linux/amd64" or "linux/arm/v7".
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/architecture[/variant]", s)
+	}
+
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Satisfies reports whether the platform satisfies the required one, a required platform without variant accepts any variant.
+func (p Platform) Satisfies(required Platform) bool {
+	return p.OS == required.OS && p.Architecture == required.Architecture && (required.Variant == "" || p.Variant == required.Variant)
+}
+
+// PlatformInterface is implemented by clients which can tell the platforms a tag is published for.
+type PlatformInterface interface {
+	Platforms(ctx context.Context, auth *Auth, repository, tag string) ([]Platform, error)
+}
+
+var _ PlatformInterface = &Client{}
+
+// Platforms retrieves the platforms a tag of a specific repository is published for, from its manifest list or OCI index
+// or, for single platform images, from the configuration of the image.
+func (c *Client) Platforms(ctx context.Context, authentication *Auth, repository, tag string) ([]Platform, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var platforms []Platform
+
+	err := c.withRepository(ctx, authentication, repository, func(repo *registryRepository) error {
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+
+		m, err := manifests.Get(ctx, "", distribution.WithTag(tag))
+		if err != nil {
+			return err
+		}
+
+		platforms, err = manifestPlatforms(ctx, repo, m)
+		return err
+	})
+
+	return platforms, err
+}
+
+// manifestPlatforms returns the platforms of a manifest, attestations of build tools which don't apply to a platform are skipped.
+func manifestPlatforms(ctx context.Context, repo distribution.Repository, m distribution.Manifest) ([]Platform, error) {
+	switch m := m.(type) {
+	case *manifestlist.DeserializedManifestList:
+		var platforms []Platform
+		for _, descriptor := range m.Manifests {
+			if descriptor.Platform.OS == "unknown" || descriptor.Platform.Architecture == "unknown" {
+				continue
+			}
+			platforms = append(platforms, Platform{
+				OS:           descriptor.Platform.OS,
+				Architecture: descriptor.Platform.Architecture,
+				Variant:      descriptor.Platform.Variant,
+			})
+		}
+		return platforms, nil
+	case *schema2.DeserializedManifest:
+		return configPlatform(ctx, repo, m.Config)
+	case *ocischema.DeserializedManifest:
+		return configPlatform(ctx, repo, m.Config)
+	default:
+		return nil, fmt.Errorf("unsupported manifest type %T", m)
+	}
+}
+
+// configPlatform returns the platform recorded in the configuration of a single platform image.
+func configPlatform(ctx context.Context, repo distribution.Repository, config distribution.Descriptor) ([]Platform, error) {
+	b, err := repo.Blobs(ctx).Get(ctx, config.Digest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var image struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	}
+
+	if err := json.Unmarshal(b, &image); err != nil {
+		return nil, fmt.Errorf("invalid image configuration %s: %v", config.Digest, err)
+	}
+
+	return []Platform{{OS: image.OS, Architecture: image.Architecture, Variant: image.Variant}}, nil
+}
@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientPlatforms(t *testing.T) {
+	config := `{"architecture":"arm","os":"linux","variant":"v7"}`
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(config)))
+
+	manifests := map[string]struct {
+		mediaType string
+		body      string
+	}{
+		"list": {
+			mediaType: "application/vnd.docker.distribution.manifest.list.v2+json",
+			body: `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[
+				{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":"sha256:1111111111111111111111111111111111111111111111111111111111111111","size":1,"platform":{"architecture":"amd64","os":"linux"}},
+				{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":"sha256:2222222222222222222222222222222222222222222222222222222222222222","size":1,"platform":{"architecture":"arm64","os":"linux","variant":"v8"}}]}`,
+		},
+		"index": {
+			mediaType: "application/vnd.oci.image.index.v1+json",
+			body: `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[
+				{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:1111111111111111111111111111111111111111111111111111111111111111","size":1,"platform":{"architecture":"amd64","os":"linux"}},
+				{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:3333333333333333333333333333333333333333333333333333333333333333","size":1,"platform":{"architecture":"unknown","os":"unknown"}}]}`,
+		},
+		"single": {
+			mediaType: "application/vnd.docker.distribution.manifest.v2+json",
+			body: fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",
+				"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":%q,"size":%d},"layers":[]}`, configDigest, len(config)),
+		},
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case req.URL.Path == "/v2/app/blobs/"+configDigest:
+			w.Write([]byte(config))
+		case strings.HasPrefix(req.URL.Path, "/v2/app/manifests/"):
+			m, exists := manifests[strings.TrimPrefix(req.URL.Path, "/v2/app/manifests/")]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", m.mediaType)
+			w.Write([]byte(m.body))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "https://") + "/app"
+	authentication := &Auth{Transport: server.Client().Transport}
+
+	tests := []struct {
+		tag      string
+		expected []Platform
+		err      bool
+	}{
+		{
+			tag:      "list",
+			expected: []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		},
+		{
+			tag:      "index",
+			expected: []Platform{{OS: "linux", Architecture: "amd64"}},
+		},
+		{
+			tag:      "single",
+			expected: []Platform{{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		},
+		{
+			tag: "missing",
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		platforms, err := (&Client{}).Platforms(context.Background(), authentication, repository, test.tag)
+
+		assert.Equal(t, test.err, err != nil, "%s: %v", test.tag, err)
+		assert.Equal(t, test.expected, platforms, test.tag)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		expected Platform
+		err      bool
+	}{
+		{platform: "linux/amd64", expected: Platform{OS: "linux", Architecture: "amd64"}},
+		{platform: "linux/arm/v7", expected: Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{platform: "amd64", err: true},
+		{platform: "linux/", err: true},
+		{platform: "linux/arm/v7/extra", err: true},
+	}
+
+	for _, test := range tests {
+		platform, err := ParsePlatform(test.platform)
+
+		assert.Equal(t, test.err, err != nil, test.platform)
+		assert.Equal(t, test.expected, platform, test.platform)
+		if !test.err {
+			assert.Equal(t, test.platform, platform.String())
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+const defaultTag = "latest"
+
+var anchoredDigestRegexp = regexp.MustCompile(`^` + reference.DigestRegexp.String() + `$`)
+
+// Reference is a parsed container image reference whose tag may be a version constraint, e.g. "registry.local:5000/app:>=1.2, <2".
+type Reference struct {
+	// Repository is the name of the image including its registry as written, e.g. "registry.local:5000/app" or "nginx".
+	Repository string
+	// Tag is the tag or version constraint of the image, "latest" when the image doesn't name one.
+	Tag string
+	// ImplicitTag reports whether the image doesn't name a tag and implicitly refers to "latest".
+	ImplicitTag bool
+	// Digest is the content digest the image is pinned to, if any.
+	Digest string
+
+	named reference.Named
+}
+
+// ParseReference parses an image of the form [registry[:port]/]name[:tag][@digest], the tag may be a version constraint.
+func ParseReference(image string) (*Reference, error) {
+	if image == "" {
+		return nil, errors.New("image can't be empty")
+	}
+
+	ref := &Reference{}
+	name := image
+
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !anchoredDigestRegexp.MatchString(ref.Digest) {
+			return nil, fmt.Errorf("invalid digest %q in image %q", ref.Digest, image)
+		}
+	}
+
+	// The tag follows the last colon of the last path component, earlier colons separate the registry port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if ref.Tag == "" {
+			return nil, fmt.Errorf("empty tag in image %q", image)
+		}
+		if strings.Contains(ref.Tag, "@") {
+			// Only the digest follows an @, neither tags nor constraints contain one.
+			return nil, fmt.Errorf("invalid tag %q in image %q", ref.Tag, image)
+		}
+	} else {
+		ref.Tag, ref.ImplicitTag = defaultTag, true
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %q: %v", image, err)
+	}
+
+	ref.Repository = name
+	ref.named = named
+
+	return ref, nil
+}
+
+// Name returns the fully qualified name of the repository, e.g. "docker.io/library/nginx" for "nginx".
+func (r *Reference) Name() string {
+	return r.named.Name()
+}
+
+// Domain returns the registry domain of the repository, e.g. "docker.io" for "nginx".
+func (r *Reference) Domain() string {
+	return reference.Domain(r.named)
+}
+
+// String returns the image as it was parsed, an implicit tag is left out.
+func (r *Reference) String() string {
+	image := r.Repository
+	if !r.ImplicitTag {
+		image += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		image += "@" + r.Digest
+	}
+	return image
+}
@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDigest = "sha256:2e2e8e2fa9f9b6cb2fc2b9c1c1e4e5d4d0d8ab1e1f1c1b1a191817161514131211"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected *Reference
+		domain   string
+		err      bool
+	}{
+		{
+			image:    "nginx:1.14.2",
+			expected: &Reference{Repository: "nginx", Tag: "1.14.2"},
+			domain:   "docker.io",
+		},
+		{
+			image:    "nginx",
+			expected: &Reference{Repository: "nginx", Tag: "latest", ImplicitTag: true},
+			domain:   "docker.io",
+		},
+		{
+			image:    "registry.local:5000/app:^1.2",
+			expected: &Reference{Repository: "registry.local:5000/app", Tag: "^1.2"},
+			domain:   "registry.local:5000",
+		},
+		{
+			image:    "registry.local:5000/team/app",
+			expected: &Reference{Repository: "registry.local:5000/team/app", Tag: "latest", ImplicitTag: true},
+			domain:   "registry.local:5000",
+		},
+		{
+			image:    "quay.io/app:>=1.2, <2",
+			expected: &Reference{Repository: "quay.io/app", Tag: ">=1.2, <2"},
+			domain:   "quay.io",
+		},
+		{
+			image:    "app@" + testDigest,
+			expected: &Reference{Repository: "app", Tag: "latest", ImplicitTag: true, Digest: testDigest},
+			domain:   "docker.io",
+		},
+		{
+			image:    "registry.local:5000/app:1.2.0@" + testDigest,
+			expected: &Reference{Repository: "registry.local:5000/app", Tag: "1.2.0", Digest: testDigest},
+			domain:   "registry.local:5000",
+		},
+		{
+			image: "",
+			err:   true,
+		},
+		{
+			image: "app:",
+			err:   true,
+		},
+		{
+			image: "app@sha256:invalid",
+			err:   true,
+		},
+		{
+			image: "App:1.0",
+			err:   true,
+		},
+		{
+			image: "app:1.@0@" + testDigest,
+			err:   true,
+		},
+		{
+			image: "registry.local:5000/:1.0",
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+
+		assert.Equal(t, test.err, err != nil, test.image)
+		if test.err {
+			continue
+		}
+
+		assert.Equal(t, test.expected.Repository, ref.Repository, test.image)
+		assert.Equal(t, test.expected.Tag, ref.Tag, test.image)
+		assert.Equal(t, test.expected.ImplicitTag, ref.ImplicitTag, test.image)
+		assert.Equal(t, test.expected.Digest, ref.Digest, test.image)
+		assert.Equal(t, test.domain, ref.Domain(), test.image)
+		assert.Equal(t, test.image, ref.String(), test.image)
+	}
+}
+
+// TestParseReferenceFuzz checks properties every parsed reference has for random references, generated from fragments
+// of valid references and random mutations of seeds so that a good share of them parses.
+func TestParseReferenceFuzz(t *testing.T) {
+	seeds := []string{
+		"nginx",
+		"nginx:1.14.2",
+		"nginx:~1.14",
+		"registry.local:5000/app:^1.2",
+		"quay.io/team/app:>=1.2, <2",
+		"app@" + testDigest,
+		"app:1.0@" + testDigest,
+		"localhost/app:1.x || 2.x",
+	}
+	fragments := []string{"nginx", "app", "team", "registry.local", "localhost", ":5000", "/", ":", "@", ".", "-", "_", "__",
+		"1", "1.14", "^", "~", ">=", "<", ", ", " || ", "x", "latest", "sha256:", testDigest[len("sha256:"):], "A", " ", ""}
+
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+
+	fuzzer := fuzz.NewWithSeed(seed).Funcs(func(image *string, c fuzz.Continue) {
+		if c.RandBool() {
+			for n := c.Intn(8); n >= 0; n-- {
+				*image += fragments[c.Intn(len(fragments))]
+			}
+			return
+		}
+
+		*image = seeds[c.Intn(len(seeds))]
+		for n := c.Intn(4); n >= 0 && *image != ""; n-- {
+			i := c.Intn(len(*image))
+			switch c.Intn(3) {
+			case 0:
+				*image = (*image)[:i] + (*image)[i+1:]
+			case 1:
+				*image = (*image)[:i] + fragments[c.Intn(len(fragments))] + (*image)[i:]
+			default:
+				*image = (*image)[:i] + c.RandString() + (*image)[i:]
+			}
+		}
+	})
+
+	var parsed int
+	for i := 0; i < 10000; i++ {
+		var image string
+		fuzzer.Fuzz(&image)
+
+		ref, err := ParseReference(image)
+		if err != nil {
+			continue
+		}
+		parsed++
+
+		if ref.Tag == "" {
+			t.Fatalf("%q: empty tag", image)
+		}
+		if ref.String() != image {
+			t.Fatalf("%q: round trip returned %q", image, ref.String())
+		}
+
+		reparsed, err := ParseReference(ref.Repository + ":" + ref.Tag)
+		if err != nil {
+			t.Fatalf("%q: reparsing failed: %v", image, err)
+		}
+		if reparsed.Repository != ref.Repository || reparsed.Tag != ref.Tag {
+			t.Fatalf("%q: reparsing returned %q and %q", image, reparsed.Repository, reparsed.Tag)
+		}
+	}
+
+	if parsed == 0 {
+		t.Fatal("no generated reference parsed")
+	}
+}
@@ -0,0 +1,301 @@
+package docker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/yaml"
+)
+
+// RegistryConfig configures how registries are reached, keyed by registry domain like "docker.io" or "registry.local:5000".
+type RegistryConfig struct {
+	Registries map[string]RegistryOptions `json:"registries,omitempty"`
+
+	mu         sync.Mutex
+	transports map[string]http.RoundTripper
+	limiters   map[string]*rate.Limiter
+}
+
+// RegistryOptions configures how a single registry is reached.
+type RegistryOptions struct {
+	// Mirrors are tried in order before the registry itself, e.g. "https://harbor.internal/dockerhub-proxy" for a pull-through cache.
+	// The path of a mirror is prepended to the repository and mirrors are accessed anonymously.
+	Mirrors []string `json:"mirrors,omitempty"`
+	// PlainHTTP talks to the registry over http instead of https, e.g. "localhost:5000" in kind clusters.
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+	// CA is the path of a PEM bundle of certificate authorities trusted in addition to the system ones.
+	CA string `json:"ca,omitempty"`
+	// Cert and Key are the paths of a PEM client certificate and its key presented to the registry.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// InsecureSkipVerify accepts any certificate presented by the registry.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// RateLimit limits the requests per second sent to the registry by this process, 0 means unlimited.
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	// Burst is the number of requests which may exceed the rate limit at once. Defaults to 1.
+	Burst int `json:"burst,omitempty"`
+}
+
+// endpoint is a registry or one of its mirrors serving a repository.
+type endpoint struct {
+	url       *url.URL
+	prefix    string
+	mirror    bool
+	transport http.RoundTripper
+	limiter   *rate.Limiter
+}
+
+func (e endpoint) String() string {
+	return strings.TrimSuffix(e.url.String()+"/"+e.prefix, "/")
+}
+
+// repository returns the name of the repository at the endpoint.
+func (e endpoint) repository(named reference.Named) (reference.Named, error) {
+	return reference.WithName(path.Join(e.prefix, reference.Path(named)))
+}
+
+// LoadRegistryConfig reads a yaml or json registry configuration.
+func LoadRegistryConfig(path string) (*RegistryConfig, error) {
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RegistryConfig{}
+
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("invalid registry config %s: %v", path, err)
+	}
+
+	for domain, options := range config.Registries {
+		for _, mirror := range options.Mirrors {
+			if _, err := parseEndpoint(mirror); err != nil {
+				return nil, fmt.Errorf("invalid mirror of %s in registry config %s: %v", domain, path, err)
+			}
+		}
+
+		if _, err := config.transport(domain); err != nil {
+			return nil, fmt.Errorf("invalid tls options of %s in registry config %s: %v", domain, path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// options returns the options of the registry of the domain. Aliases of docker hub share their options,
+// the options of the first configured alias in the order of dockerHubAliases apply to those which aren't configured.
+func (c *RegistryConfig) options(domain string) RegistryOptions {
+	if c == nil {
+		return RegistryOptions{}
+	}
+
+	if options, exists := c.Registries[domain]; exists {
+		return options
+	}
+
+	if dockerHubHosts[domain] {
+		for _, host := range dockerHubAliases {
+			if options, exists := c.Registries[host]; exists {
+				return options
+			}
+		}
+	}
+	return RegistryOptions{}
+}
+
+// endpoints returns the mirrors of the registry of the repository in order followed by the registry itself.
+// Every endpoint uses the transport configured for its own host, so mirrors may use a private CA as well.
+func (c *RegistryConfig) endpoints(named reference.Named) ([]endpoint, error) {
+	var endpoints []endpoint
+
+	domain := reference.Domain(named)
+	options := c.options(domain)
+
+	for _, mirror := range options.Mirrors {
+		e, err := parseEndpoint(mirror)
+		if err != nil {
+			return nil, err
+		}
+		e.mirror = true
+		endpoints = append(endpoints, e)
+	}
+
+	registryURL, err := getRegistryURL(named)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PlainHTTP {
+		registryURL.Scheme = "http"
+	}
+
+	endpoints = append(endpoints, endpoint{url: registryURL})
+
+	for i := range endpoints {
+		host := endpoints[i].url.Host
+		if !endpoints[i].mirror {
+			host = domain
+		}
+
+		if endpoints[i].transport, err = c.transport(host); err != nil {
+			return nil, fmt.Errorf("invalid tls options of %s: %v", host, err)
+		}
+		endpoints[i].limiter = c.limiter(host)
+	}
+
+	return endpoints, nil
+}
+
+// transport returns the transport of the registry of the domain, nil when it has no tls options.
+// Transports are created once per registry so their connections are reused.
+func (c *RegistryConfig) transport(domain string) (http.RoundTripper, error) {
+	options := c.options(domain)
+
+	if options.CA == "" && options.Cert == "" && options.Key == "" && !options.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if transport, exists := c.transports[domain]; exists {
+		return transport, nil
+	}
+
+	tlsConfig, err := options.tlsConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The same settings as http.DefaultTransport.
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	if c.transports == nil {
+		c.transports = map[string]http.RoundTripper{}
+	}
+	c.transports[domain] = transport
+
+	return transport, nil
+}
+
+// limiter returns the rate limiter shared by every request to the registry of the domain, nil when it isn't rate limited.
+func (c *RegistryConfig) limiter(domain string) *rate.Limiter {
+	options := c.options(domain)
+
+	if options.RateLimit <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limiter, exists := c.limiters[domain]; exists {
+		return limiter
+	}
+
+	burst := options.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	if c.limiters == nil {
+		c.limiters = map[string]*rate.Limiter{}
+	}
+	c.limiters[domain] = rate.NewLimiter(rate.Limit(options.RateLimit), burst)
+
+	return c.limiters[domain]
+}
+
+// tlsConfig returns the tls configuration of the options.
+func (o RegistryOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CA != "" {
+		pem, err := ioutil.ReadFile(o.CA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.Cert != "" || o.Key != "" {
+		if o.Cert == "" || o.Key == "" {
+			return nil, errors.New("client certificates require both cert and key")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseEndpoint parses an endpoint like "harbor.internal/dockerhub-proxy", https is used when no scheme is given.
+func parseEndpoint(rawurl string) (endpoint, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		return endpoint{}, err
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return endpoint{}, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return endpoint{}, fmt.Errorf("%q has no host", rawurl)
+	}
+
+	return endpoint{
+		url:    &url.URL{Scheme: u.Scheme, Host: u.Host},
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+	// rateLimitRemainingHeader is sent by docker hub, e.g. "ratelimit-remaining: 0;w=21600".
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+)
+
+// retryTransport retries requests which failed with a status registries use for transient failures and rate limiting.
+// The delay honours the Retry-After header and otherwise backs off exponentially with jitter, a request is never retried
+// when the delay would exceed the deadline of its context, in which case the failed response is returned.
+type retryTransport struct {
+	next      http.RoundTripper
+	limiter   *rate.Limiter
+	retries   int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(req)
+
+		if err != nil || !retryable(resp.StatusCode) || attempt >= t.retries {
+			return resp, err
+		}
+
+		// Requests whose body can't be replayed are not retried.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay, ok := t.delay(resp, attempt)
+
+		if deadline, hasDeadline := ctx.Deadline(); !ok || (hasDeadline && time.Until(deadline) < delay) {
+			return resp, nil
+		}
+
+		glog.V(2).Infof("%s %s returned %s, retrying in %s", req.Method, req.URL, resp.Status, delay)
+		resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.WithContext(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// delay returns how long to wait before retrying a failed response and whether it should be retried at all.
+// A rate limited response without Retry-After whose RateLimit-Remaining is 0 isn't retried, as its window is typically hours.
+func (t *retryTransport) delay(resp *http.Response, attempt int) (time.Duration, bool) {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay, true
+			}
+			return 0, true
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests && rateLimitRemaining(resp) == 0 {
+		return 0, false
+	}
+
+	delay := t.baseDelay << uint(attempt)
+	if delay <= 0 || delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+
+	// Full jitter over the upper half keeps concurrent retries apart without retrying too early.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// retryable reports whether a status indicates a transient failure.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rateLimitRemaining returns the number of remaining requests of the RateLimit-Remaining header, -1 when it is missing.
+func rateLimitRemaining(resp *http.Response) int {
+	value := resp.Header.Get(rateLimitRemainingHeader)
+
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[:i]
+	}
+
+	remaining, err := strconv.Atoi(strings.TrimSpace(value))
+
+	if err != nil {
+		return -1
+	}
+	return remaining
+}
@@ -0,0 +1,182 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRetryTransport(t *testing.T) {
+	type response struct {
+		status int
+		header map[string]string
+	}
+
+	tests := []struct {
+		responses []response
+		retries   int
+		timeout   time.Duration
+		expected  int
+		attempts  int32
+	}{
+		{
+			responses: []response{{status: http.StatusBadGateway}, {status: http.StatusOK}},
+			retries:   3,
+			expected:  http.StatusOK,
+			attempts:  2,
+		},
+		{
+			responses: []response{{status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "0"}}, {status: http.StatusOK}},
+			retries:   3,
+			expected:  http.StatusOK,
+			attempts:  2,
+		},
+		{
+			// Docker hub's pull limit resets after hours, waiting for it is pointless.
+			responses: []response{{status: http.StatusTooManyRequests, header: map[string]string{"RateLimit-Remaining": "0;w=21600"}}, {status: http.StatusOK}},
+			retries:   3,
+			expected:  http.StatusTooManyRequests,
+			attempts:  1,
+		},
+		{
+			responses: []response{{status: http.StatusTooManyRequests, header: map[string]string{"RateLimit-Remaining": "5;w=21600"}}, {status: http.StatusOK}},
+			retries:   3,
+			expected:  http.StatusOK,
+			attempts:  2,
+		},
+		{
+			// The delay exceeds the deadline, so the response is returned right away.
+			responses: []response{{status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "60"}}, {status: http.StatusOK}},
+			retries:   3,
+			timeout:   time.Second,
+			expected:  http.StatusTooManyRequests,
+			attempts:  1,
+		},
+		{
+			responses: []response{{status: http.StatusServiceUnavailable}},
+			retries:   2,
+			expected:  http.StatusServiceUnavailable,
+			attempts:  3,
+		},
+		{
+			responses: []response{{status: http.StatusNotFound}, {status: http.StatusOK}},
+			retries:   3,
+			expected:  http.StatusNotFound,
+			attempts:  1,
+		},
+	}
+
+	for _, test := range tests {
+		var attempts int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			i := int(atomic.AddInt32(&attempts, 1)) - 1
+			if i >= len(test.responses) {
+				i = len(test.responses) - 1
+			}
+			for key, value := range test.responses[i].header {
+				w.Header().Set(key, value)
+			}
+			w.WriteHeader(test.responses[i].status)
+		}))
+
+		ctx := context.Background()
+		cancel := func() {}
+		if test.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, test.timeout)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tr := &retryTransport{
+			next:      http.DefaultTransport,
+			retries:   test.retries,
+			baseDelay: time.Millisecond,
+			maxDelay:  10 * time.Millisecond,
+		}
+
+		start := time.Now()
+		resp, err := tr.RoundTrip(req.WithContext(ctx))
+
+		assert.NoError(t, err)
+		if err == nil {
+			resp.Body.Close()
+			assert.Equal(t, test.expected, resp.StatusCode, "%v", test.responses)
+		}
+		assert.Equal(t, test.attempts, atomic.LoadInt32(&attempts), "%v", test.responses)
+		assert.True(t, time.Since(start) < time.Second, "took %s", time.Since(start))
+
+		cancel()
+		server.Close()
+	}
+}
+
+func TestRetryTransportLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	tr := &retryTransport{
+		next:    http.DefaultTransport,
+		limiter: rate.NewLimiter(rate.Every(time.Minute), 1),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := tr.RoundTrip(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	// The next token is only available in a minute.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = tr.RoundTrip(req.WithContext(ctx))
+	assert.Error(t, err)
+}
+
+func TestClientTagsRetry(t *testing.T) {
+	var failed int32
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/app/tags/list":
+			if atomic.CompareAndSwapInt32(&failed, 0, 1) {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"name":"app","tags":["1.0.0"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "https://") + "/app"
+
+	tags, err := (&Client{Retries: 3}).Tags(context.Background(), &Auth{Transport: server.Client().Transport}, repository)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+
+	atomic.StoreInt32(&failed, 0)
+
+	_, err = (&Client{}).Tags(context.Background(), &Auth{Transport: server.Client().Transport}, repository)
+
+	assert.Error(t, err)
+}
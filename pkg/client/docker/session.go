@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+)
+
+// defaultSessionTTL is how long the challenges of a registry are reused before it is pinged again.
+const defaultSessionTTL = 30 * time.Minute
+
+// session holds what was negotiated with an endpoint for a set of credentials: the authentication challenges of its ping
+// and a token handler per repository, each caching its bearer token until it expires.
+// Sessions outlive single calls, so their token requests aren't bound to the context of a call.
+type session struct {
+	expires    time.Time
+	challenges challenge.Manager
+	creds      auth.CredentialStore
+	transport  http.RoundTripper
+
+	mu       sync.Mutex
+	handlers map[string]auth.AuthenticationHandler
+}
+
+// tokenHandler returns the token handler for pulling the repository.
+func (s *session) tokenHandler(repository string) auth.AuthenticationHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if handler, exists := s.handlers[repository]; exists {
+		return handler
+	}
+
+	handler := auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+		Transport:   s.transport,
+		Credentials: s.creds,
+		Scopes: []auth.Scope{auth.RepositoryScope{
+			Repository: repository,
+			Actions:    []string{"pull"},
+		}},
+		ClientID: "docker",
+	})
+	s.handlers[repository] = handler
+
+	return handler
+}
+
+// session returns the session with the endpoint for the credentials, the endpoint is pinged when there is none yet or it expired.
+func (c *Client) session(ctx context.Context, authentication *Auth, e endpoint) (*session, error) {
+	key := e.String() + "|" + credentialIdentity(authentication)
+
+	c.mu.Lock()
+	s, exists := c.sessions[key]
+	c.mu.Unlock()
+
+	if exists && time.Now().Before(s.expires) {
+		return s, nil
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, c.pingTimeout())
+	defer cancel()
+
+	challenges, _, err := PingV2Registry(pingCtx, e.url, transport.NewTransport(authentication.Transport, userAgent()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s = &session{
+		expires:    time.Now().Add(defaultSessionTTL),
+		challenges: challenges,
+		creds:      NewCredentialStore(authentication.Username, authentication.Password, authentication.IdentityToken),
+		transport:  transport.NewTransport(authentication.Transport, userAgent()),
+		handlers:   map[string]auth.AuthenticationHandler{},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sessions == nil {
+		c.sessions = map[string]*session{}
+	}
+
+	now := time.Now()
+	for k, other := range c.sessions {
+		if now.After(other.expires) {
+			delete(c.sessions, k)
+		}
+	}
+	c.sessions[key] = s
+
+	return s, nil
+}
+
+// forgetSession drops the session with the endpoint for the credentials, so the next call negotiates a new one.
+func (c *Client) forgetSession(authentication *Auth, e endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, e.String()+"|"+credentialIdentity(authentication))
+}
+
+// contextHandler stops waiting for a token once the context of the request is done,
+// the token request itself is limited by the timeout of the token handler.
+// The handler authorizes a copy of the request, whose headers are only copied over once it finished in time,
+// so a handler still running after the request was abandoned never writes to headers the transport may be reading.
+type contextHandler struct {
+	auth.AuthenticationHandler
+}
+
+func (h contextHandler) AuthorizeRequest(req *http.Request, params map[string]string) error {
+	authorized := req.WithContext(req.Context())
+	authorized.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		authorized.Header[key] = append([]string(nil), values...)
+	}
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- h.AuthenticationHandler.AuthorizeRequest(authorized, params)
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			return err
+		}
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		for key, values := range authorized.Header {
+			req.Header[key] = values
+		}
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func userAgent() transport.RequestModifier {
+	return transport.NewHeaderRequestModifier(http.Header{"User-Agent": []string{authClientID}})
+}
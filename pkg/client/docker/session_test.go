@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientSession(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	token := "first"
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests[req.URL.Path]++
+		current := token
+		mu.Unlock()
+
+		switch req.URL.Path {
+		case "/token":
+			fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, current)
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer "+current {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/v2/app/tags/list", "/v2/other/tags/list":
+			w.Write([]byte(`{"tags":["1.0.0"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	domain := strings.TrimPrefix(server.URL, "https://")
+	client := &Client{}
+	authentication := func() *Auth {
+		return &Auth{Username: "user", Password: "pass", Transport: server.Client().Transport}
+	}
+
+	tags, err := client.Tags(context.Background(), authentication(), domain+"/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+	assert.Equal(t, map[string]int{"/v2/": 1, "/token": 1, "/v2/app/tags/list": 1}, requests)
+
+	// The challenges and the token are reused.
+	tags, err = client.Tags(context.Background(), authentication(), domain+"/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+	assert.Equal(t, map[string]int{"/v2/": 1, "/token": 1, "/v2/app/tags/list": 2}, requests)
+
+	// Other repositories need a token of their own.
+	_, err = client.Tags(context.Background(), authentication(), domain+"/other")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests["/token"])
+	assert.Equal(t, 1, requests["/v2/"])
+
+	// Other credentials negotiate a session of their own.
+	_, err = client.Tags(context.Background(), &Auth{Transport: server.Client().Transport}, domain+"/app")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests["/v2/"])
+
+	// A revoked token fails the call and drops the session, so the next call starts afresh.
+	mu.Lock()
+	token = "second"
+	mu.Unlock()
+
+	_, err = client.Tags(context.Background(), authentication(), domain+"/app")
+	assert.Error(t, err)
+
+	tags, err = client.Tags(context.Background(), authentication(), domain+"/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+	assert.Equal(t, 3, requests["/v2/"])
+}
+
+type testAuthenticationHandler struct {
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (h *testAuthenticationHandler) Scheme() string {
+	return "bearer"
+}
+
+func (h *testAuthenticationHandler) AuthorizeRequest(req *http.Request, params map[string]string) error {
+	defer close(h.done)
+	<-h.release
+	req.Header.Set("Authorization", "Bearer token")
+	return nil
+}
+
+func TestContextHandler(t *testing.T) {
+	handler := &testAuthenticationHandler{release: make(chan struct{}), done: make(chan struct{})}
+	close(handler.release)
+
+	req := httptest.NewRequest(http.MethodGet, "https://registry.local/v2/", nil)
+	req.Header.Set("User-Agent", "test")
+
+	assert.NoError(t, contextHandler{handler}.AuthorizeRequest(req, nil))
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+	assert.Equal(t, "test", req.Header.Get("User-Agent"))
+
+	// A handler finishing after the request was abandoned leaves its headers alone.
+	handler = &testAuthenticationHandler{release: make(chan struct{}), done: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	req = httptest.NewRequest(http.MethodGet, "https://registry.local/v2/", nil).WithContext(ctx)
+	cancel()
+
+	assert.Equal(t, context.Canceled, contextHandler{handler}.AuthorizeRequest(req, nil))
+	close(handler.release)
+	<-handler.done
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestCredentialStoreRefreshToken(t *testing.T) {
+	realm, err := url.Parse("https://auth.docker.io/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewCredentialStore("user", "pass", "identity")
+	assert.Equal(t, "identity", store.RefreshToken(realm, "registry.docker.io"))
+
+	store.SetRefreshToken(realm, "registry.docker.io", "issued")
+	assert.Equal(t, "issued", store.RefreshToken(realm, "registry.docker.io"))
+	assert.Equal(t, "identity", store.RefreshToken(realm, "other"))
+
+	store = NewCredentialStore("user", "pass", "")
+	assert.Equal(t, "", store.RefreshToken(realm, "registry.docker.io"))
+}
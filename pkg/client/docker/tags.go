@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/golang/glog"
+)
+
+// registryRepository is a repository at an endpoint together with a client authorized to pull it.
+type registryRepository struct {
+	distribution.Repository
+
+	name   reference.Named
+	url    *url.URL
+	client *http.Client
+}
+
+// listTags lists the tags of the repository following the pagination of the registry, which links to the next page in the Link header.
+// Listing stops once maxTags tags were listed or done reports that the tags listed so far suffice, a maxTags of 0 lists every tag.
+func (r *registryRepository) listTags(ctx context.Context, pageSize, maxTags int, done func(tags []string) bool) ([]string, error) {
+	next := &url.URL{
+		Path:     "/v2/" + r.name.Name() + "/tags/list",
+		RawQuery: url.Values{"n": []string{strconv.Itoa(pageSize)}}.Encode(),
+	}
+	next = r.url.ResolveReference(next)
+
+	var tags []string
+
+	for next != nil {
+		page, link, err := r.tagsPage(ctx, next)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, page...)
+
+		if maxTags > 0 && len(tags) >= maxTags {
+			if len(tags) > maxTags || link != "" {
+				glog.Warningf("%s has more than %d tags, the remaining tags are ignored and may include newer versions", r.name.Name(), maxTags)
+			}
+			return tags[:maxTags], nil
+		}
+
+		if done != nil && done(tags) {
+			glog.V(2).Infof("stopped listing the tags of %s after %d tags", r.name.Name(), len(tags))
+			return tags, nil
+		}
+
+		if next, err = nextPage(next, link); err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// tagsPage retrieves a page of tags and the Link header pointing to the next one.
+func (r *registryRepository) tagsPage(ctx context.Context, u *url.URL) ([]string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", client.HandleErrorResponse(resp)
+	}
+
+	var page struct {
+		Tags []string `json:"tags"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", fmt.Errorf("invalid tag list of %s: %v", r.name.Name(), err)
+	}
+
+	return page.Tags, resp.Header.Get("Link"), nil
+}
+
+// nextPage returns the url of the next page of a Link header like `</v2/app/tags/list?last=1.0&n=100>; rel="next"`, nil on the last page.
+func nextPage(current *url.URL, link string) (*url.URL, error) {
+	for _, value := range strings.Split(link, ",") {
+		parts := strings.Split(value, ";")
+		target := strings.TrimSpace(parts[0])
+
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.Replace(strings.Replace(strings.TrimSpace(param), " ", "", -1), `"`, "", -1) != "rel=next" {
+				continue
+			}
+
+			u, err := url.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Link header %q: %v", link, err)
+			}
+
+			return current.ResolveReference(u), nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,205 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tagRegistry is a fake registry serving the tags of the repository "app" page by page like docker distribution does.
+type tagRegistry struct {
+	*httptest.Server
+	tags  []string
+	pages int32
+}
+
+func newTagRegistry(tags []string) *tagRegistry {
+	r := &tagRegistry{tags: append([]string(nil), tags...)}
+	sort.Strings(r.tags)
+
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/app/tags/list":
+			atomic.AddInt32(&r.pages, 1)
+
+			n, err := strconv.Atoi(req.URL.Query().Get("n"))
+			if err != nil || n <= 0 {
+				n = len(r.tags)
+			}
+
+			last := req.URL.Query().Get("last")
+			start := sort.SearchStrings(r.tags, last)
+			if start < len(r.tags) && r.tags[start] == last {
+				start++
+			}
+
+			end := start + n
+			if end >= len(r.tags) {
+				end = len(r.tags)
+			} else {
+				next := url.Values{"n": []string{strconv.Itoa(n)}, "last": []string{r.tags[end-1]}}
+				w.Header().Set("Link", fmt.Sprintf(`</v2/app/tags/list?%s>; rel="next"`, next.Encode()))
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "app", "tags": r.tags[start:end]})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return r
+}
+
+func (r *tagRegistry) repository() string {
+	return strings.TrimPrefix(r.URL, "https://") + "/app"
+}
+
+func versionTags(n int) []string {
+	tags := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		tags = append(tags, fmt.Sprintf("%d.%d.%d", i/1000, i/10%100, i%10))
+	}
+	return tags
+}
+
+func TestClientTagsPagination(t *testing.T) {
+	tags := versionTags(250)
+
+	tests := []struct {
+		client   *Client
+		done     func(tags []string) bool
+		expected int
+		pages    int32
+	}{
+		{
+			client:   &Client{PageSize: 100},
+			expected: 250,
+			pages:    3,
+		},
+		{
+			client:   &Client{PageSize: 50},
+			expected: 250,
+			pages:    5,
+		},
+		{
+			client:   &Client{},
+			expected: 250,
+			pages:    1,
+		},
+		{
+			client:   &Client{PageSize: 100, MaxTags: 150},
+			expected: 150,
+			pages:    2,
+		},
+		{
+			client:   &Client{PageSize: 100, MaxTags: 100},
+			expected: 100,
+			pages:    1,
+		},
+		{
+			client: &Client{PageSize: 100},
+			done: func(tags []string) bool {
+				return len(tags) >= 100
+			},
+			expected: 100,
+			pages:    1,
+		},
+	}
+
+	for i, test := range tests {
+		registry := newTagRegistry(tags)
+
+		listed, err := test.client.TagsUntil(context.Background(), &Auth{Transport: registry.Client().Transport}, registry.repository(), test.done)
+
+		assert.NoError(t, err, "test %d", i)
+		assert.Len(t, listed, test.expected, "test %d", i)
+		assert.Equal(t, registry.tags[:test.expected], listed, "test %d", i)
+		assert.Equal(t, test.pages, atomic.LoadInt32(&registry.pages), "test %d", i)
+
+		registry.Close()
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	current, err := url.Parse("https://registry.local/v2/app/tags/list?n=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{link: ""},
+		{
+			link:     `</v2/app/tags/list?last=1.0&n=100>; rel="next"`,
+			expected: "https://registry.local/v2/app/tags/list?last=1.0&n=100",
+		},
+		{
+			link:     `<https://mirror.local/v2/app/tags/list?last=1.0>; rel=next`,
+			expected: "https://mirror.local/v2/app/tags/list?last=1.0",
+		},
+		{
+			link:     `</v2/app/tags/list?last=0.9>; rel="prev", </v2/app/tags/list?last=1.0>; rel="next"`,
+			expected: "https://registry.local/v2/app/tags/list?last=1.0",
+		},
+		{link: `</v2/app/tags/list?last=0.9>; rel="prev"`},
+	}
+
+	for _, test := range tests {
+		next, err := nextPage(current, test.link)
+
+		assert.NoError(t, err, test.link)
+		if test.expected == "" {
+			assert.Nil(t, next, test.link)
+		} else if assert.NotNil(t, next, test.link) {
+			assert.Equal(t, test.expected, next.String(), test.link)
+		}
+	}
+}
+
+func BenchmarkClientTags(b *testing.B) {
+	registry := newTagRegistry(versionTags(20000))
+	defer registry.Close()
+
+	authentication := &Auth{Transport: registry.Client().Transport}
+
+	benchmarks := []struct {
+		name   string
+		client *Client
+		done   func(tags []string) bool
+	}{
+		{name: "page-100", client: &Client{PageSize: 100}},
+		{name: "page-1000", client: &Client{PageSize: 1000}},
+		{name: "max-5000", client: &Client{PageSize: 1000, MaxTags: 5000}},
+		{
+			name:   "early-termination",
+			client: &Client{PageSize: 1000},
+			done: func(tags []string) bool {
+				return len(tags) >= 2000
+			},
+		},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := benchmark.client.TagsUntil(context.Background(), authentication, registry.repository(), benchmark.done); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jw-s/updatey/pkg/k8s"
+
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	deploymentKind  = "Deployment"
+	statefulSetKind = "StatefulSet"
+	daemonSetKind   = "DaemonSet"
+	cronJobKind     = "CronJob"
+)
+
+// Controller periodically re-resolves the image constraints of workloads and patches them when a newer version is available.
+type Controller struct {
+	kubeClient             kubernetes.Interface
+	dynamicClient          dynamic.Interface
+	wrapper                k8s.Interface
+	queue                  workqueue.RateLimitingInterface
+	informerFactory        informers.SharedInformerFactory
+	dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	informers              map[string]cache.SharedIndexInformer
+	// dynamicCronJobs reports that CronJobs are watched and patched as batch/v1 through the dynamic client.
+	dynamicCronJobs bool
+}
+
+// cronJobs is the batch/v1 CronJob resource, which the typed client doesn't know about yet.
+var cronJobs = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+
+// New returns a new Controller which watches Deployments, StatefulSets, DaemonSets and CronJobs using the informer factories.
+// The resync period of the factories determines how often every workload is reconciled.
+// CronJobs are watched as batch/v1 through the dynamic informer factory, clusters which only serve batch/v1beta1 fall back to
+// the typed informer. CronJobs aren't watched on clusters serving neither, otherwise waiting for their cache would never finish.
+func New(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, informerFactory informers.SharedInformerFactory,
+	dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory, wrapper k8s.Interface) *Controller {
+	c := &Controller{
+		kubeClient:             kubeClient,
+		dynamicClient:          dynamicClient,
+		wrapper:                wrapper,
+		queue:                  workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "updatey"),
+		informerFactory:        informerFactory,
+		dynamicInformerFactory: dynamicInformerFactory,
+		informers: map[string]cache.SharedIndexInformer{
+			deploymentKind:  informerFactory.Apps().V1().Deployments().Informer(),
+			statefulSetKind: informerFactory.Apps().V1().StatefulSets().Informer(),
+			daemonSetKind:   informerFactory.Apps().V1().DaemonSets().Informer(),
+		},
+	}
+
+	if err := servesResource(kubeClient.Discovery(), cronJobs.GroupVersion().String(), cronJobs.Resource); err == nil {
+		c.informers[cronJobKind] = dynamicInformerFactory.ForResource(cronJobs).Informer()
+		c.dynamicCronJobs = true
+	} else if fallbackErr := servesResource(kubeClient.Discovery(), batchv1beta1.SchemeGroupVersion.String(), cronJobs.Resource); fallbackErr == nil {
+		c.informers[cronJobKind] = informerFactory.Batch().V1beta1().CronJobs().Informer()
+	} else {
+		glog.Errorf("not reconciling %ss: %v, %v", cronJobKind, err, fallbackErr)
+	}
+
+	for kind, informer := range c.informers {
+		informer.AddEventHandler(c.resyncHandler(kind))
+	}
+
+	return c
+}
+
+// resyncHandler only enqueues objects on periodic resyncs, real updates already went through the admission webhook.
+func (c *Controller) resyncHandler(kind string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldAccessor, ok := oldObj.(resourceVersioned)
+			if !ok {
+				return
+			}
+			newAccessor, ok := newObj.(resourceVersioned)
+			if !ok {
+				return
+			}
+			if oldAccessor.GetResourceVersion() != newAccessor.GetResourceVersion() {
+				return
+			}
+			c.enqueue(kind, newObj)
+		},
+	}
+}
+
+// servesResource returns an error unless the cluster serves the resource in the group version.
+func servesResource(client discovery.DiscoveryInterface, groupVersion, resource string) error {
+	resources, err := client.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s isn't served by %s", resource, groupVersion)
+}
+
+type resourceVersioned interface {
+	GetResourceVersion() string
+}
+
+func (c *Controller) enqueue(kind string, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(kind + "/" + key)
+}
+
+// Run starts the workers and blocks until the stop channel is closed.
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	c.informerFactory.Start(stopCh)
+	c.dynamicInformerFactory.Start(stopCh)
+
+	var synced []cache.InformerSynced
+	for _, informer := range c.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *Controller) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(key.(string)); err != nil {
+		glog.Errorf("failed to reconcile %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) sync(key string) error {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid key: %s", key)
+	}
+	kind, objectKey := parts[0], parts[1]
+
+	informer, exists := c.informers[kind]
+	if !exists {
+		return fmt.Errorf("unsupported kind: %s", kind)
+	}
+
+	obj, exists, err := informer.GetIndexer().GetByKey(objectKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(objectKey)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	patches, err := c.wrapper.GetObjectPatches(context.Background(), kind, raw)
+	if err != nil {
+		return err
+	}
+
+	patches, err = changedPatches(raw, patches)
+	if err != nil {
+		return err
+	}
+
+	if len(patches) == 0 {
+		return nil
+	}
+
+	accessor, ok := obj.(resourceVersioned)
+	if !ok {
+		return fmt.Errorf("%s %s has no resource version", kind, objectKey)
+	}
+
+	// The patches only apply to the object they were computed from. When it changed since it was cached, e.g. by a user or
+	// another replica, the patch fails and the object is reconciled again once the cache caught up.
+	patches = append([]*k8s.JSONPatch{{
+		Op:    "test",
+		Path:  "/metadata/resourceVersion",
+		Value: accessor.GetResourceVersion(),
+	}}, patches...)
+
+	data, err := json.Marshal(patches)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("patching %s %s/%s: %s", kind, namespace, name, data)
+
+	return c.patch(kind, namespace, name, data)
+}
+
+func (c *Controller) patch(kind, namespace, name string, data []byte) (err error) {
+	switch kind {
+	case deploymentKind:
+		_, err = c.kubeClient.AppsV1().Deployments(namespace).Patch(name, types.JSONPatchType, data)
+	case statefulSetKind:
+		_, err = c.kubeClient.AppsV1().StatefulSets(namespace).Patch(name, types.JSONPatchType, data)
+	case daemonSetKind:
+		_, err = c.kubeClient.AppsV1().DaemonSets(namespace).Patch(name, types.JSONPatchType, data)
+	case cronJobKind:
+		if c.dynamicCronJobs {
+			_, err = c.dynamicClient.Resource(cronJobs).Namespace(namespace).Patch(name, types.JSONPatchType, data, metav1.UpdateOptions{})
+			break
+		}
+		_, err = c.kubeClient.BatchV1beta1().CronJobs(namespace).Patch(name, types.JSONPatchType, data)
+	default:
+		err = fmt.Errorf("unsupported kind: %s", kind)
+	}
+	return err
+}
+
+// changedPatches filters out the patches which would not change the raw json document.
+func changedPatches(raw []byte, patches []*k8s.JSONPatch) (changed []*k8s.JSONPatch, err error) {
+	var document interface{}
+	if err = json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	for _, patch := range patches {
+		if patch.Op == "add" || patch.Op == "replace" {
+			current, exists := lookup(document, patch.Path)
+			if exists {
+				value, err := normalize(patch.Value)
+				if err != nil {
+					return nil, err
+				}
+				if reflect.DeepEqual(current, value) {
+					continue
+				}
+			}
+		}
+		changed = append(changed, patch)
+	}
+	return changed, nil
+}
+
+// lookup resolves a json pointer as per https://tools.ietf.org/html/rfc6901 against a decoded json document.
+func lookup(document interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return document, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	current := document
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, exists := node[token]
+			if !exists {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func normalize(value interface{}) (normalized interface{}, err error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &normalized)
+	return normalized, err
+}
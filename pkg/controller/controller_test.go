@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jw-s/updatey/pkg/k8s"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testWrapper struct {
+	k8s.Interface
+	patches []*k8s.JSONPatch
+	kinds   []string
+}
+
+func (w *testWrapper) GetObjectPatches(ctx context.Context, kind string, raw []byte) ([]*k8s.JSONPatch, error) {
+	w.kinds = append(w.kinds, kind)
+	return w.patches, nil
+}
+
+func (w *testWrapper) GetPatches(ctx context.Context, ar *v1beta1.AdmissionRequest) ([]*k8s.JSONPatch, error) {
+	return w.GetObjectPatches(ctx, ar.Kind.Kind, ar.Object.Raw)
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		image    string
+		patches  []*k8s.JSONPatch
+		expected string
+		err      bool
+	}{
+		{
+			image: "nginx:1.14.1",
+			patches: []*k8s.JSONPatch{
+				{
+					Op:    "replace",
+					Path:  "/spec/template/spec/containers/0/image",
+					Value: "nginx:1.14.2",
+				},
+			},
+			expected: "nginx:1.14.2",
+		},
+		{
+			image: "nginx:1.14.2",
+			patches: []*k8s.JSONPatch{
+				{
+					Op:    "replace",
+					Path:  "/spec/template/spec/containers/0/image",
+					Value: "nginx:1.14.2",
+				},
+			},
+			expected: "nginx:1.14.2",
+		},
+		{
+			image:    "nginx:1.14.2",
+			expected: "nginx:1.14.2",
+		},
+	}
+
+	for _, test := range tests {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web",
+				Namespace:       "default",
+				ResourceVersion: "1",
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{
+								Name:  "nginx",
+								Image: test.image,
+							},
+						},
+					},
+				},
+			},
+		}
+
+		kubeClient := fake.NewSimpleClientset(deployment)
+		wrapper := &testWrapper{patches: test.patches}
+		c := newController(kubeClient, wrapper)
+		c.informers[deploymentKind].GetIndexer().Add(deployment)
+
+		err := c.sync("Deployment/default/web")
+		assert.Equal(t, test.err, err != nil)
+		assert.Equal(t, []string{deploymentKind}, wrapper.kinds)
+
+		result, err := kubeClient.AppsV1().Deployments("default").Get("web", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.expected, result.Spec.Template.Spec.Containers[0].Image)
+
+		var patchActions int
+		for _, action := range kubeClient.Actions() {
+			if action.GetVerb() == "patch" {
+				patchActions++
+			}
+		}
+		if test.image == test.expected {
+			assert.Equal(t, 0, patchActions)
+		} else {
+			assert.Equal(t, 1, patchActions)
+		}
+	}
+}
+
+func TestSyncStaleCache(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "default",
+			ResourceVersion: "2",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.15.0",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// The cache still holds the version from before the image was changed.
+	cached := deployment.DeepCopy()
+	cached.ResourceVersion = "1"
+	cached.Spec.Template.Spec.Containers[0].Image = "nginx:1.14.1"
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	wrapper := &testWrapper{patches: []*k8s.JSONPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/template/spec/containers/0/image",
+			Value: "nginx:1.14.2",
+		},
+	}}
+	c := newController(kubeClient, wrapper)
+	c.informers[deploymentKind].GetIndexer().Add(cached)
+
+	assert.Error(t, c.sync("Deployment/default/web"))
+
+	result, err := kubeClient.AppsV1().Deployments("default").Get("web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "nginx:1.15.0", result.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestSyncMissingObject(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	wrapper := &testWrapper{}
+	c := newController(kubeClient, wrapper)
+
+	assert.NoError(t, c.sync("Deployment/default/web"))
+	assert.Empty(t, wrapper.kinds)
+	assert.Error(t, c.sync("Pod/default/web"))
+}
+
+func newController(kubeClient *fake.Clientset, wrapper k8s.Interface, objects ...runtime.Object) *Controller {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	return New(kubeClient, dynamicClient, informers.NewSharedInformerFactory(kubeClient, 0),
+		dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0), wrapper)
+}
+
+func TestNewCronJobs(t *testing.T) {
+	tests := []struct {
+		groupVersions []string
+		watched       bool
+		dynamic       bool
+	}{
+		{
+			// Clusters serving neither version only reconcile the other kinds.
+		},
+		{
+			groupVersions: []string{"batch/v1beta1"},
+			watched:       true,
+		},
+		{
+			groupVersions: []string{"batch/v1beta1", "batch/v1"},
+			watched:       true,
+			dynamic:       true,
+		},
+		{
+			groupVersions: []string{"batch/v1"},
+			watched:       true,
+			dynamic:       true,
+		},
+	}
+
+	for _, test := range tests {
+		kubeClient := fake.NewSimpleClientset()
+		for _, groupVersion := range test.groupVersions {
+			kubeClient.Resources = append(kubeClient.Resources, &metav1.APIResourceList{
+				GroupVersion: groupVersion,
+				APIResources: []metav1.APIResource{{Name: "cronjobs", Kind: cronJobKind}},
+			})
+		}
+
+		c := newController(kubeClient, &testWrapper{})
+
+		assert.Contains(t, c.informers, deploymentKind)
+		_, watched := c.informers[cronJobKind]
+		assert.Equal(t, test.watched, watched, "%v", test.groupVersions)
+		assert.Equal(t, test.dynamic, c.dynamicCronJobs, "%v", test.groupVersions)
+	}
+}
+
+func TestSyncCronJob(t *testing.T) {
+	cronJob := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       cronJobKind,
+		"metadata": map[string]interface{}{
+			"name":            "backup",
+			"namespace":       "default",
+			"resourceVersion": "1",
+		},
+		"spec": map[string]interface{}{
+			"jobTemplate": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"containers": []interface{}{
+								map[string]interface{}{
+									"name":  "backup",
+									"image": "backup:1.0.0",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "batch/v1",
+			APIResources: []metav1.APIResource{{Name: "cronjobs", Kind: cronJobKind}},
+		},
+	}
+	wrapper := &testWrapper{patches: []*k8s.JSONPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/jobTemplate/spec/template/spec/containers/0/image",
+			Value: "backup:1.1.0",
+		},
+	}}
+	c := newController(kubeClient, wrapper, cronJob)
+	c.informers[cronJobKind].GetIndexer().Add(cronJob)
+
+	assert.NoError(t, c.sync("CronJob/default/backup"))
+	assert.Equal(t, []string{cronJobKind}, wrapper.kinds)
+
+	result, err := c.dynamicClient.Resource(cronJobs).Namespace("default").Get("backup", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers, _, _ := unstructured.NestedSlice(result.Object, "spec", "jobTemplate", "spec", "template", "spec", "containers")
+	assert.Equal(t, "backup:1.1.0", containers[0].(map[string]interface{})["image"])
+}
+
+func TestLookup(t *testing.T) {
+	document := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				"updatey/constraints": "value",
+			},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"image": "nginx:1.14.2",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		pointer  string
+		expected interface{}
+		exists   bool
+	}{
+		{
+			pointer:  "/spec/containers/0/image",
+			expected: "nginx:1.14.2",
+			exists:   true,
+		},
+		{
+			pointer:  "/metadata/annotations/updatey~1constraints",
+			expected: "value",
+			exists:   true,
+		},
+		{
+			pointer: "/spec/containers/1/image",
+		},
+		{
+			pointer: "/spec/initContainers",
+		},
+		{
+			pointer: "spec",
+		},
+	}
+
+	for _, test := range tests {
+		value, exists := lookup(document, test.pointer)
+		assert.Equal(t, test.exists, exists, test.pointer)
+		assert.Equal(t, test.expected, value, test.pointer)
+	}
+}
@@ -0,0 +1,50 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jw-s/updatey/pkg/client/docker"
+)
+
+// minimumAge returns how old the image of a tag has to be before it is chosen for the object,
+// the MinAgeAnnotation takes precedence over the minimum age of the wrapper.
+func (w *Wrapper) minimumAge(metadata []metadataRef) time.Duration {
+	value := annotation(metadata, MinAgeAnnotation)
+
+	if value == "" {
+		return w.minAge
+	}
+
+	minAge, err := time.ParseDuration(value)
+
+	if err != nil || minAge < 0 {
+		glog.Errorf("invalid %s annotation %q, using %s instead", MinAgeAnnotation, value, w.minAge)
+		return w.minAge
+	}
+
+	return minAge
+}
+
+// ageCheck rejects tags whose image was created less than the minimum age of the image ago, nil when there is nothing to check.
+func (w *Wrapper) ageCheck(authentication *docker.Auth, containerImage *containerImage) tagCheck {
+	createdClient, ok := w.dockerClient.(docker.CreatedInterface)
+
+	if !ok || containerImage.minAge <= 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, tag string) (string, error) {
+		created, err := createdClient.Created(ctx, authentication, containerImage.repository, tag)
+		if err != nil {
+			return "", fmt.Errorf("unable to inspect the creation time of %s:%s: %v", containerImage.repository, tag, err)
+		}
+
+		if age := time.Since(created); age < containerImage.minAge {
+			return fmt.Sprintf("was created %s ago, less than %s", age.Truncate(time.Second), containerImage.minAge), nil
+		}
+		return "", nil
+	}
+}
@@ -1,22 +1,45 @@
 package k8s
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/jw-s/updatey/pkg/client/docker"
+	"github.com/jw-s/updatey/pkg/version"
 
 	"k8s.io/api/admission/v1beta1"
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
 	podSpecPath      = "/spec"
 	templateSpecPath = "/spec/template/spec"
 	cronJobSpecPath  = "/spec/jobTemplate/spec/template/spec"
+
+	objectMetadataPath   = "/metadata"
+	templateMetadataPath = "/spec/template/metadata"
+	cronJobMetadataPath  = "/spec/jobTemplate/spec/template/metadata"
+
+	// ConstraintsAnnotation stores the original version constraint of every container, keyed by container type and name.
+	ConstraintsAnnotation = "updatey/constraints"
+	// PinDigestAnnotation opts an object into pinning resolved images to their content digest when set to "true".
+	PinDigestAnnotation = "updatey/pin-digest"
+	// EnabledAnnotation opts an object out of updatey when set to "false", or into it when updatey runs in opt-in mode and it is set to "true".
+	EnabledAnnotation = "updatey/enabled"
+	// IgnoreContainersAnnotation lists the names of containers, separated by commas, whose images updatey leaves untouched.
+	IgnoreContainersAnnotation = "updatey/ignore-containers"
+	// MinAgeAnnotation overrides how old the image of a tag has to be before it is chosen, e.g. "72h", "0" disables the cooldown.
+	MinAgeAnnotation = "updatey/min-age"
 )
 
 // JSONPatch is the type which stores the json patch format as per http://jsonpatch.com.
@@ -31,105 +54,588 @@ type templateKind struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              struct {
 		Template struct {
-			Spec corev1.PodSpec `json:"spec,omitempty"`
+			metav1.ObjectMeta `json:"metadata,omitempty"`
+			Spec              corev1.PodSpec `json:"spec,omitempty"`
 		} `json:"template,omitempty"`
 	} `json:"spec,omitempty"`
 }
 
+// metadataRef points to object metadata within the object being patched.
+type metadataRef struct {
+	path     string
+	metadata *metav1.ObjectMeta
+}
+
 // GetPatches returns a slice of json patches based on the admission request and possibily an error.
-func (w *Wrapper) GetPatches(ar *v1beta1.AdmissionRequest) (patches []*JSONPatch, err error) {
-	var (
-		spec      *corev1.PodSpec
-		specPath  string
-		namespace string
-	)
-
-	switch ar.Kind.Kind {
+// Objects without a namespace of their own, which is common on creation, are in the namespace of the request.
+func (w *Wrapper) GetPatches(ctx context.Context, ar *v1beta1.AdmissionRequest) (patches []*JSONPatch, err error) {
+	object, err := decodePodObject(ar.Kind.Kind, ar.Object.Raw)
+	if err != nil || object == nil {
+		return nil, err
+	}
+
+	if object.namespace == "" {
+		object.namespace = ar.Namespace
+	}
+
+	return w.objectPatches(ctx, object)
+}
+
+// podObject is the pod spec of an object along with the metadata which applies to it.
+type podObject struct {
+	spec      *corev1.PodSpec
+	specPath  string
+	namespace string
+	metadata  []metadataRef
+}
+
+// decodePodObject decodes the raw json representation of an object of the given kind, unsupported kinds return nil.
+func decodePodObject(kind string, raw []byte) (*podObject, error) {
+	switch kind {
 	case "Pod":
 		pod := corev1.Pod{}
-		err = json.Unmarshal(ar.Object.Raw, &pod)
-		if err != nil {
+		if err := json.Unmarshal(raw, &pod); err != nil {
 			return nil, err
 		}
-		spec, specPath, namespace = &pod.Spec, podSpecPath, pod.Namespace
+		return &podObject{
+			spec:      &pod.Spec,
+			specPath:  podSpecPath,
+			namespace: pod.Namespace,
+			metadata:  []metadataRef{{objectMetadataPath, &pod.ObjectMeta}},
+		}, nil
 	case "ReplicationController", "Job", "ReplicaSet", "Deployment", "StatefulSet", "DaemonSet":
 		templateKind := templateKind{}
-		err = json.Unmarshal(ar.Object.Raw, &templateKind)
-		if err != nil {
+		if err := json.Unmarshal(raw, &templateKind); err != nil {
 			return nil, err
 		}
-		spec, specPath, namespace = &templateKind.Spec.Template.Spec, templateSpecPath, templateKind.Namespace
+		return &podObject{
+			spec:      &templateKind.Spec.Template.Spec,
+			specPath:  templateSpecPath,
+			namespace: templateKind.Namespace,
+			metadata:  []metadataRef{{objectMetadataPath, &templateKind.ObjectMeta}, {templateMetadataPath, &templateKind.Spec.Template.ObjectMeta}},
+		}, nil
 	case "CronJob":
 		cronJob := batchv1beta1.CronJob{}
-		err = json.Unmarshal(ar.Object.Raw, &cronJob)
-		if err != nil {
+		if err := json.Unmarshal(raw, &cronJob); err != nil {
 			return nil, err
 		}
-		spec, specPath, namespace = &cronJob.Spec.JobTemplate.Spec.Template.Spec, cronJobSpecPath, cronJob.Namespace
+		return &podObject{
+			spec:      &cronJob.Spec.JobTemplate.Spec.Template.Spec,
+			specPath:  cronJobSpecPath,
+			namespace: cronJob.Namespace,
+			metadata:  []metadataRef{{objectMetadataPath, &cronJob.ObjectMeta}, {cronJobMetadataPath, &cronJob.Spec.JobTemplate.Spec.Template.ObjectMeta}},
+		}, nil
 	default:
 		return nil, nil
 	}
+}
+
+// GetObjectPatches returns a slice of json patches based on the raw json representation of an object of the given kind and possibily an error.
+func (w *Wrapper) GetObjectPatches(ctx context.Context, kind string, raw []byte) (patches []*JSONPatch, err error) {
+	object, err := decodePodObject(kind, raw)
+	if err != nil || object == nil {
+		return nil, err
+	}
 
-	return w.processPodSpec(spec, specPath, namespace)
+	return w.objectPatches(ctx, object)
 }
 
-func (w *Wrapper) processPodSpec(podSpec *corev1.PodSpec, specPath, namespace string) (patches []*JSONPatch, err error) {
-	for _, containerType := range []string{"initContainers", "containers"} {
-		var containers []corev1.Container
-		switch containerType {
-		case "initContainers":
-			containers = podSpec.InitContainers
-		case "containers":
-			containers = podSpec.Containers
-		}
+// objectPatches returns the json patches of an object, objects updatey is disabled for are left untouched without asking any registry.
+func (w *Wrapper) objectPatches(ctx context.Context, object *podObject) (patches []*JSONPatch, err error) {
+	if !w.enabled(object) {
+		glog.V(2).Infof("updatey is disabled for %s in namespace %q", object.metadata[0].metadata.Name, object.namespace)
+		return nil, nil
+	}
+
+	// Objects owned by a controller follow their owner's template, re-resolving their recorded constraints would let them drift apart.
+	owned := metav1.GetControllerOf(object.metadata[0].metadata) != nil
+
+	var constraints map[string]string
+	if !owned {
+		constraints = recordedConstraints(object.metadata)
+	}
 
-		secrets, err := w.GetImagePullSecrets(podSpec.ImagePullSecrets, namespace)
+	// Pinning owned objects would pin them to whatever the tag points to now instead of what their owner's template was admitted with.
+	pinDigest := !owned && (w.pinDigest || annotation(object.metadata, PinDigestAnnotation) == "true")
 
+	patches, constraints, err = w.processPodSpec(ctx, object, constraints, pinDigest, w.minimumAge(object.metadata))
+	if err != nil || owned {
+		return patches, err
+	}
+
+	for _, ref := range object.metadata {
+		patch, err := constraintsPatch(ref, constraints)
 		if err != nil {
 			return patches, err
 		}
+		if patch != nil {
+			patches = append(patches, patch)
+		}
+	}
 
-	containerLoop:
-		for containerIndex, container := range containers {
+	return patches, nil
+}
+
+// containerImage is the image of a single container which is resolved independently of the other containers.
+type containerImage struct {
+	path       string
+	current    string
+	tag        string
+	digest     string
+	image      string
+	repository string
+	constraint string
+	platforms  []docker.Platform
+	minAge     time.Duration
+}
+
+type resolvedImage struct {
+	index int
+	patch *JSONPatch
+}
+
+func (w *Wrapper) processPodSpec(ctx context.Context, object *podObject, constraints map[string]string, pinDigest bool, minAge time.Duration) (patches []*JSONPatch, recorded map[string]string, err error) {
+	podSpec, specPath, namespace := object.spec, object.specPath, object.namespace
+	ignored := ignoredContainers(object.metadata)
+	recorded = map[string]string{}
 
-			repository, tag, err := docker.Split(container.Image)
+	var images []*containerImage
+
+	platforms := requiredPlatforms(podSpec, w.platforms)
+
+	for _, containerType := range containerTypes {
+	containerLoop:
+		for containerIndex, container := range containersOf(podSpec, containerType) {
+			ref, err := docker.ParseReference(container.Image)
 			if err != nil {
 				glog.Error(err)
 				continue containerLoop
 			}
 
-			tags, err := w.dockerClient.Tags(nil, repository)
+			if ref.ImplicitTag && ref.Digest != "" {
+				// Images only referred to by their digest can't change.
+				continue containerLoop
+			}
 
-			if err != nil {
-			secretLoop:
-				for _, secret := range secrets {
-					username, password, err := ExtractFromDockerSecret(secret, container.Image)
-					if err != nil {
-						glog.Error(err)
-						continue secretLoop
-					}
-
-					tags, err = w.dockerClient.Tags(&docker.Auth{
-						Username: username,
-						Password: password,
-					}, repository)
-
-					if err != nil {
-						glog.Error(err)
-						continue secretLoop
-					}
-
-					break secretLoop
+			tag := ref.Tag
+			constraintKey := containerType + "/" + container.Name
+			if ignored[container.Name] {
+				// Ignored containers keep their recorded constraint for when they are no longer ignored.
+				if constraint, exists := constraints[constraintKey]; exists {
+					recorded[constraintKey] = constraint
 				}
+				continue containerLoop
+			} else if version.IsConstraint(tag) {
+				recorded[constraintKey] = tag
+			} else if constraint, exists := constraints[constraintKey]; exists && w.satisfies(constraint, tag) {
+				recorded[constraintKey] = constraint
+				tag = constraint
+			} else {
+				// Literal tags are kept as they are, there is no need to ask the registry about them.
+				continue containerLoop
 			}
-			newImageVersion := w.resolver.Resolve(tag, tags)
 
-			patches = append(patches, &JSONPatch{
-				Op:    "replace",
-				Path:  fmt.Sprintf("%s/%s/%v/image", specPath, containerType, containerIndex),
-				Value: fmt.Sprintf("%s:%s", repository, newImageVersion),
+			images = append(images, &containerImage{
+				path:       fmt.Sprintf("%s/%s/%v/image", specPath, containerType, containerIndex),
+				current:    container.Image,
+				tag:        ref.Tag,
+				digest:     ref.Digest,
+				image:      container.Image,
+				repository: ref.Repository,
+				constraint: tag,
+				platforms:  platforms,
+				minAge:     minAge,
 			})
 		}
 	}
-	return patches, nil
+
+	if len(images) == 0 {
+		// Neither image pull secrets nor registries are needed when no container has to be resolved.
+		return patches, recorded, nil
+	}
+
+	secrets, err := w.GetImagePullSecrets(ctx, podSpec.ImagePullSecrets, podSpec.ServiceAccountName, namespace)
+
+	if err != nil {
+		return patches, recorded, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.resolveTimeout)
+	defer cancel()
+
+	// Both channels are buffered so workers never block on them, even once the deadline passed and nobody is listening anymore.
+	jobs := make(chan int, len(images))
+	results := make(chan resolvedImage, len(images))
+
+	for i := range images {
+		jobs <- i
+	}
+	close(jobs)
+
+	for worker := 0; worker < w.concurrency && worker < len(images); worker++ {
+		go func() {
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				results <- resolvedImage{
+					index: i,
+					patch: w.resolveImage(ctx, images[i], secrets, pinDigest),
+				}
+			}
+		}()
+	}
+
+	resolved := make([]*JSONPatch, len(images))
+
+resultLoop:
+	for received := 0; received < len(images); received++ {
+		select {
+		case result := <-results:
+			resolved[result.index] = result.patch
+		case <-ctx.Done():
+			glog.Errorf("resolving images of %s stopped: %v", specPath, ctx.Err())
+			break resultLoop
+		}
+	}
+
+	for _, patch := range resolved {
+		if patch != nil {
+			patches = append(patches, patch)
+		}
+	}
+	return patches, recorded, nil
+}
+
+// resolveImage returns the patch which replaces the image with the version resolved from its constraint,
+// images whose constraint can't be resolved, which can't be pinned or whose value wouldn't change are left untouched.
+func (w *Wrapper) resolveImage(ctx context.Context, containerImage *containerImage, secrets []*corev1.Secret, pinDigest bool) *JSONPatch {
+	// An older tag is chosen when the newest one fails a check, so every tag has to be listed then.
+	stopEarly := len(containerImage.platforms) == 0 && containerImage.minAge <= 0
+
+	tags, authentication, err := w.tags(ctx, containerImage.repository, containerImage.image, containerImage.constraint, stopEarly, secrets)
+	if err != nil {
+		glog.Errorf("unable to list the tags of %s: %v", containerImage.repository, err)
+	}
+
+	result, err := w.resolveChecked(ctx, containerImage, tags, w.platformCheck(authentication, containerImage), w.ageCheck(authentication, containerImage))
+	if err != nil {
+		glog.Errorf("unable to resolve %s: %v", containerImage.image, err)
+		return nil
+	}
+
+	newImageVersion := result.Version
+	image := fmt.Sprintf("%s:%s", containerImage.repository, newImageVersion)
+
+	if containerImage.digest != "" && newImageVersion == containerImage.tag {
+		// The image is already pinned to the resolved tag, re-pinning it would let it drift from the pods admitted earlier.
+		return nil
+	}
+
+	if pinDigest {
+		digest, err := w.dockerClient.Digest(ctx, authentication, containerImage.repository, newImageVersion)
+		if err != nil {
+			// Images which have to be pinned are never admitted with a mutable tag, the constraint is left for the validating webhook to deny.
+			glog.Errorf("unable to pin %s to a digest, leaving %s untouched: %v", image, containerImage.current, err)
+			return nil
+		}
+		image = fmt.Sprintf("%s@%s", image, digest)
+	}
+
+	if image == containerImage.current {
+		return nil
+	}
+
+	return &JSONPatch{
+		Op:    "replace",
+		Path:  containerImage.path,
+		Value: image,
+	}
+}
+
+// maxTagChecks bounds how many of the newest matching tags are checked before giving up.
+const maxTagChecks = 10
+
+// tagCheck returns why a tag can't be chosen, an empty reason when it can.
+type tagCheck func(ctx context.Context, tag string) (reason string, err error)
+
+// resolveChecked resolves the constraint of the image to the newest matching tag passing every check, tags failing one are rejected in turn.
+// Literal tags aren't checked, nil checks are skipped.
+func (w *Wrapper) resolveChecked(ctx context.Context, containerImage *containerImage, tags []string, checks ...tagCheck) (*version.Result, error) {
+	result, err := w.resolver.Resolve(containerImage.constraint, tags)
+
+	var active []tagCheck
+	for _, check := range checks {
+		if check != nil {
+			active = append(active, check)
+		}
+	}
+
+	if err != nil || len(active) == 0 || !result.Constraint {
+		return result, err
+	}
+
+	rejected := map[string]string{}
+	candidates := append([]string(nil), tags...)
+
+	defer func() {
+		// Resolvers may return no result along with an error.
+		if result == nil {
+			return
+		}
+		if result.Rejected == nil {
+			result.Rejected = map[string]string{}
+		}
+		for tag, reason := range rejected {
+			result.Rejected[tag] = reason
+		}
+	}()
+
+	for checked := 0; ; checked++ {
+		if checked == maxTagChecks {
+			return result, fmt.Errorf("none of the %d newest tags matching %q can be chosen", maxTagChecks, containerImage.constraint)
+		}
+
+		var reason string
+		for _, check := range active {
+			if reason, err = check(ctx, result.Version); err != nil {
+				return result, err
+			}
+			if reason != "" {
+				break
+			}
+		}
+
+		if reason == "" {
+			return result, nil
+		}
+
+		glog.V(2).Infof("%s:%s %s", containerImage.repository, result.Version, reason)
+		rejected[result.Version] = reason
+		candidates = without(candidates, result.Version)
+
+		if result, err = w.resolver.Resolve(containerImage.constraint, candidates); err != nil {
+			return result, err
+		}
+	}
+}
+
+func without(tags []string, tag string) []string {
+	var remaining []string
+	for _, t := range tags {
+		if t != tag {
+			remaining = append(remaining, t)
+		}
+	}
+	return remaining
+}
+
+var containerTypes = []string{"initContainers", "containers"}
+
+// containersOf returns the containers of the pod spec for the container type as named in the pod spec json.
+func containersOf(podSpec *corev1.PodSpec, containerType string) []corev1.Container {
+	switch containerType {
+	case "initContainers":
+		return podSpec.InitContainers
+	case "containers":
+		return podSpec.Containers
+	}
+	return nil
+}
+
+// credential is a way of authenticating to a registry, anonymous access has no authentication.
+type credential struct {
+	name           string
+	authentication *docker.Auth
+}
+
+// credentials returns the credentials to try for the image in the order of the credential strategy.
+// The image pull secrets come before the credential sources, those without credentials for the registry of the image are skipped
+// as are those repeating earlier credentials.
+func (w *Wrapper) credentials(ctx context.Context, image string, secrets []*corev1.Secret) []credential {
+	var authenticated []credential
+	seen := map[string]bool{}
+
+	add := func(name string, authentication *docker.Auth) {
+		key := authentication.Username + "\x00" + authentication.Password + "\x00" + authentication.IdentityToken
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		authenticated = append(authenticated, credential{
+			name:           name,
+			authentication: authentication,
+		})
+	}
+
+	for _, secret := range secrets {
+		authentication, err := ExtractFromDockerSecret(secret, image)
+		if err != nil {
+			glog.V(2).Infof("skipping secret %s for %s: %v", secret.Name, image, err)
+			continue
+		}
+		add("secret "+secret.Name, authentication)
+	}
+
+	if ref, err := docker.ParseReference(image); err == nil {
+		for _, source := range w.credentialSources {
+			authentication, err := source.Credentials(ctx, ref)
+			if err != nil {
+				glog.V(2).Infof("skipping %v for %s: %v", source, image, err)
+				continue
+			}
+			add(fmt.Sprint(source), authentication)
+		}
+	}
+
+	anonymous := credential{name: "anonymous access"}
+
+	switch w.credentialStrategy {
+	case AnonymousFirst:
+		return append([]credential{anonymous}, authenticated...)
+	case AuthOnly:
+		return authenticated
+	default:
+		return append(authenticated, anonymous)
+	}
+}
+
+// tags returns the tags of the repository and the authentication which was used to retrieve them.
+// Every credential is tried in turn until one succeeds, otherwise the failures of all of them are returned.
+// The credentials form a single lookup, so mirrors which are accessed anonymously are only asked once.
+func (w *Wrapper) tags(ctx context.Context, repository, image, constraint string, stopEarly bool, secrets []*corev1.Secret) (tags []string, authentication *docker.Auth, err error) {
+	var errs []error
+
+	ctx = docker.WithLookup(ctx)
+
+	for _, credential := range w.credentials(ctx, image, secrets) {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+
+		tags, err := w.listTags(ctx, credential.authentication, repository, constraint, stopEarly)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", credential.name, err))
+			continue
+		}
+
+		glog.V(2).Infof("listed the tags of %s using %s", repository, credential.name)
+		return tags, credential.authentication, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, nil, errors.New("no credentials for its registry")
+	}
+
+	return nil, nil, utilerrors.NewAggregate(errs)
+}
+
+// listTags lists the tags of the repository, when stopping early is allowed clients listing tags page by page stop
+// once the resolver proves that none of the remaining tags would be chosen for the constraint.
+func (w *Wrapper) listTags(ctx context.Context, authentication *docker.Auth, repository, constraint string, stopEarly bool) ([]string, error) {
+	pagedClient, paged := w.dockerClient.(docker.PagedInterface)
+	boundedResolver, bounded := w.resolver.(version.Bounded)
+
+	if !stopEarly || !paged || !bounded {
+		return w.dockerClient.Tags(ctx, authentication, repository)
+	}
+
+	return pagedClient.TagsUntil(ctx, authentication, repository, func(tags []string) bool {
+		return boundedResolver.Final(constraint, tags)
+	})
+}
+
+// annotation returns the value of an annotation on the metadata, later entries take precedence.
+func annotation(metadata []metadataRef, key string) (value string) {
+	for _, ref := range metadata {
+		if v, exists := ref.metadata.Annotations[key]; exists {
+			value = v
+		}
+	}
+	return value
+}
+
+// satisfies reports whether a literal tag is still within the recorded constraint,
+// a tag outside of it means the image was deliberately changed and the constraint no longer applies.
+func (w *Wrapper) satisfies(constraint, tag string) bool {
+	result, err := w.resolver.Resolve(constraint, []string{tag})
+	return err == nil && result.Version == tag
+}
+
+// recordedConstraints returns the constraints recorded on the metadata, later entries take precedence.
+func recordedConstraints(metadata []metadataRef) map[string]string {
+	constraints := map[string]string{}
+	for _, ref := range metadata {
+		value, exists := ref.metadata.Annotations[ConstraintsAnnotation]
+		if !exists {
+			continue
+		}
+
+		var recorded map[string]string
+		if err := json.Unmarshal([]byte(value), &recorded); err != nil {
+			glog.Errorf("invalid %s annotation: %v", ConstraintsAnnotation, err)
+			continue
+		}
+
+		for key, constraint := range recorded {
+			constraints[key] = constraint
+		}
+	}
+	return constraints
+}
+
+// constraintsPatch returns a patch which records the constraints on the metadata or nil when the annotation is already up to date.
+func constraintsPatch(ref metadataRef, constraints map[string]string) (*JSONPatch, error) {
+	current, exists := ref.metadata.Annotations[ConstraintsAnnotation]
+	annotationPath := ref.path + "/annotations/" + escapeJSONPointer(ConstraintsAnnotation)
+
+	if len(constraints) == 0 {
+		if !exists {
+			return nil, nil
+		}
+		return &JSONPatch{
+			Op:   "remove",
+			Path: annotationPath,
+		}, nil
+	}
+
+	if exists {
+		var recorded map[string]string
+		if err := json.Unmarshal([]byte(current), &recorded); err == nil && reflect.DeepEqual(recorded, constraints) {
+			return nil, nil
+		}
+	}
+
+	// Constraints like ">=1.2, <2" are recorded as written rather than escaped for html.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(constraints); err != nil {
+		return nil, err
+	}
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	if ref.metadata.Annotations == nil {
+		return &JSONPatch{
+			Op:   "add",
+			Path: ref.path + "/annotations",
+			Value: map[string]string{
+				ConstraintsAnnotation: string(b),
+			},
+		}, nil
+	}
+
+	return &JSONPatch{
+		Op:    "add",
+		Path:  annotationPath,
+		Value: string(b),
+	}, nil
+}
+
+// escapeJSONPointer escapes a json pointer reference token as per https://tools.ietf.org/html/rfc6901.
+func escapeJSONPointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
 }
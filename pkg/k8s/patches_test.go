@@ -1,9 +1,13 @@
 package k8s
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -19,11 +23,12 @@ import (
 )
 
 type testDockerClient struct {
-	tags [][]string
-	errs []error
+	tags    [][]string
+	errs    []error
+	digests map[string]string
 }
 
-func (c *testDockerClient) Tags(auth *docker.Auth, repository string) ([]string, error) {
+func (c *testDockerClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
 	var tags []string
 	var err error
 	if len(c.tags) == 1 && len(c.errs) == 1 {
@@ -37,12 +42,20 @@ func (c *testDockerClient) Tags(auth *docker.Auth, repository string) ([]string,
 	}
 }
 
+func (c *testDockerClient) Digest(ctx context.Context, auth *docker.Auth, repository, tag string) (string, error) {
+	digest, exists := c.digests[repository+":"+tag]
+	if !exists {
+		return "", errors.New("manifest unknown")
+	}
+	return digest, nil
+}
+
 type testResolver struct {
 	resolve string
 }
 
-func (r *testResolver) Resolve(constraint string, tags []string) string {
-	return r.resolve
+func (r *testResolver) Resolve(constraint string, tags []string) (*version.Result, error) {
+	return &version.Result{Version: r.resolve, Candidates: len(tags)}, nil
 }
 
 func TestGetPatches(t *testing.T) {
@@ -65,7 +78,8 @@ func TestGetPatches(t *testing.T) {
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -90,6 +104,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -103,7 +124,8 @@ func TestGetPatches(t *testing.T) {
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -130,6 +152,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -151,7 +180,8 @@ func TestGetPatches(t *testing.T) {
 					},
 					Containers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -194,6 +224,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -207,7 +244,8 @@ func TestGetPatches(t *testing.T) {
 				Spec: corev1.PodSpec{
 					InitContainers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -232,6 +270,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -245,7 +290,8 @@ func TestGetPatches(t *testing.T) {
 				Spec: corev1.PodSpec{
 					InitContainers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -272,6 +318,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -285,7 +338,8 @@ func TestGetPatches(t *testing.T) {
 				Spec: corev1.PodSpec{
 					InitContainers: []corev1.Container{
 						{
-							Image: "alpine:latest",
+							Name:  "alpine",
+							Image: "alpine:~1.0",
 						},
 					},
 				},
@@ -312,6 +366,13 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -327,7 +388,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -354,6 +416,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -369,7 +445,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -398,6 +475,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -413,7 +504,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -442,6 +534,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -457,7 +563,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -484,6 +591,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -499,7 +620,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -528,6 +650,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -543,7 +679,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -572,6 +709,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -587,7 +738,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -614,6 +766,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -629,7 +795,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -658,6 +825,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -673,7 +854,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -702,6 +884,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -717,7 +913,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -744,6 +941,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -759,7 +970,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -788,6 +1000,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -803,7 +1029,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -832,6 +1059,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -847,7 +1088,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -874,6 +1116,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -889,7 +1145,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -918,6 +1175,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -933,7 +1204,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -962,6 +1234,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -977,7 +1263,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1004,6 +1291,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1019,7 +1320,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1048,6 +1350,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1063,7 +1379,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1092,6 +1409,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1107,7 +1438,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1134,6 +1466,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1149,7 +1495,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1178,6 +1525,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1193,7 +1554,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1222,6 +1584,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1237,7 +1613,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1264,6 +1641,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1279,7 +1670,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1308,6 +1700,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1323,7 +1729,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1352,6 +1759,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1367,7 +1788,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1394,6 +1816,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1409,7 +1845,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1438,6 +1875,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1453,7 +1904,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1482,6 +1934,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1497,7 +1963,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1524,6 +1991,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1539,7 +2020,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1568,6 +2050,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1583,7 +2079,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1612,6 +2109,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1627,7 +2138,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1654,6 +2166,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1669,7 +2195,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1698,6 +2225,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1713,7 +2254,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							Containers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1742,6 +2284,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1757,7 +2313,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1784,6 +2341,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1799,7 +2370,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1828,6 +2400,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1843,7 +2429,8 @@ func TestGetPatches(t *testing.T) {
 						Spec: corev1.PodSpec{
 							InitContainers: []corev1.Container{
 								{
-									Image: "alpine:latest",
+									Name:  "alpine",
+									Image: "alpine:~1.0",
 								},
 							},
 						},
@@ -1872,6 +2459,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1889,7 +2490,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									Containers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -1920,6 +2522,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1937,7 +2553,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									Containers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -1966,6 +2583,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -1983,7 +2614,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									Containers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -2014,7 +2646,21 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/containers/0/image",
 					Value: "alpine:1.0",
 				},
-			},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/alpine":"~1.0"}`,
+					},
+				},
+			},
 		},
 		{
 			o: &batchv1beta1.CronJob{
@@ -2031,7 +2677,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									InitContainers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -2060,6 +2707,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -2077,7 +2738,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									InitContainers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -2108,6 +2770,20 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
 			},
 		},
 		{
@@ -2125,7 +2801,8 @@ func TestGetPatches(t *testing.T) {
 								Spec: corev1.PodSpec{
 									InitContainers: []corev1.Container{
 										{
-											Image: "alpine:latest",
+											Name:  "alpine",
+											Image: "alpine:~1.0",
 										},
 									},
 								},
@@ -2156,7 +2833,48 @@ func TestGetPatches(t *testing.T) {
 					Path:  "/spec/jobTemplate/spec/template/spec/initContainers/0/image",
 					Value: "alpine:1.0",
 				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/jobTemplate/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/alpine":"~1.0"}`,
+					},
+				},
+			},
+		},
+		{
+			// Literal tags are neither looked up nor patched.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "alpine",
+							Image: "alpine:latest",
+						},
+						{
+							Name:  "nginx",
+							Image: "nginx:1.14.2",
+						},
+					},
+				},
 			},
+			dockerClient:    &testDockerClient{},
+			resolver:        &testResolver{},
+			secretRetriever: &testSecretRetriever{},
+			expected:        nil,
 		},
 		{
 			o: &corev1.Service{
@@ -2174,7 +2892,7 @@ func TestGetPatches(t *testing.T) {
 	for _, test := range tests {
 		w := New(test.secretRetriever, test.resolver, test.dockerClient)
 
-		patches, err := w.GetPatches(createAdmissionRequest(test.o))
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(test.o))
 
 		assert.Equal(t, test.err, err)
 
@@ -2199,3 +2917,1467 @@ func createAdmissionRequest(o runtime.Object) *v1beta1.AdmissionRequest {
 		},
 	}
 }
+
+func TestGetPatchesConstraintsAnnotation(t *testing.T) {
+	isController := true
+
+	tests := []struct {
+		o        runtime.Object
+		tags     []string
+		expected []*JSONPatch
+	}{
+		{
+			// Registries with a port and constraints containing spaces.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "app",
+							Image: "registry.local:5000/app:>=1.2, <2",
+						},
+					},
+				},
+			},
+			tags: []string{"1.1.0", "1.2.0", "1.3.1"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/containers/0/image",
+					Value: "registry.local:5000/app:1.3.1",
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/app":">=1.2, <2"}`,
+					},
+				},
+			},
+		},
+		{
+			// Constraints which match none of the tags leave the image untouched.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:^2.0",
+						},
+					},
+				},
+			},
+			tags: []string{"1.14.1", "1.14.2"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"^2.0"}`,
+					},
+				},
+			},
+		},
+		{
+			o: &appsv1.Deployment{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Deployment",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name:  "nginx",
+									Image: "nginx:~1.14",
+								},
+							},
+						},
+					},
+				},
+			},
+			tags: []string{"1.14.1", "1.14.2", "1.15.0"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/template/spec/containers/0/image",
+					Value: "nginx:1.14.2",
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/spec/template/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+			},
+		},
+		{
+			o: &appsv1.Deployment{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Deployment",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						"other": "annotation",
+					},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Annotations: map[string]string{
+								ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name:  "nginx",
+									Image: "nginx:1.14.1",
+								},
+							},
+						},
+					},
+				},
+			},
+			tags: []string{"1.14.1", "1.14.2", "1.15.0"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/template/spec/containers/0/image",
+					Value: "nginx:1.14.2",
+				},
+				&JSONPatch{
+					Op:    "add",
+					Path:  "/metadata/annotations/updatey~1constraints",
+					Value: `{"containers/nginx":"~1.14"}`,
+				},
+			},
+		},
+		{
+			o: &batchv1beta1.CronJob{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "CronJob",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						ConstraintsAnnotation: `{"initContainers/init":"^1.0"}`,
+					},
+				},
+				Spec: batchv1beta1.CronJobSpec{
+					JobTemplate: batchv1beta1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									Annotations: map[string]string{
+										ConstraintsAnnotation: `{"initContainers/init":"^1.0"}`,
+									},
+								},
+								Spec: corev1.PodSpec{
+									InitContainers: []corev1.Container{
+										{
+											Name:  "init",
+											Image: "busybox:1.0.0",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			tags: []string{"1.0.0", "1.1.0", "2.0.0"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/jobTemplate/spec/template/spec/initContainers/0/image",
+					Value: "busybox:1.1.0",
+				},
+			},
+		},
+		{
+			// The image was deliberately pinned outside of the recorded constraint.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.13.0",
+						},
+					},
+				},
+			},
+			tags: []string{"1.13.0", "1.14.2"},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:   "remove",
+					Path: "/metadata/annotations/updatey~1constraints",
+				},
+			},
+		},
+		{
+			// Pods owned by a controller follow their template.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind:       "ReplicaSet",
+							Name:       "test",
+							Controller: &isController,
+						},
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.14.1",
+						},
+					},
+				},
+			},
+			tags: []string{"1.14.1", "1.14.2"},
+		},
+	}
+
+	for _, test := range tests {
+		dockerClient := &testDockerClient{
+			tags: [][]string{test.tags},
+			errs: []error{nil},
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient)
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(test.o))
+
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, test.expected, patches)
+	}
+}
+
+func TestGetPatchesPinDigest(t *testing.T) {
+	const digest = "sha256:2e2e8e2fa9f9b6cb2fc2b9c1c1e4e5d4d0d8ab1e1f1c1b1a191817161514131211"
+	isController := true
+
+	tests := []struct {
+		o         runtime.Object
+		pinDigest bool
+		expected  []*JSONPatch
+	}{
+		{
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:~1.14",
+						},
+					},
+				},
+			},
+			pinDigest: true,
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/containers/0/image",
+					Value: "nginx:1.14.2@" + digest,
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+			},
+		},
+		{
+			// Literal tags are left untouched, even with digest pinning.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Image: "nginx:1.14.2",
+						},
+					},
+				},
+			},
+			pinDigest: true,
+		},
+		{
+			// Constraints resolve to plain tags without digest pinning.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:~1.14",
+						},
+					},
+				},
+			},
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/containers/0/image",
+					Value: "nginx:1.14.2",
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+			},
+		},
+		{
+			// Literal tags are left untouched without digest pinning.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Image: "nginx:1.14.2",
+						},
+					},
+				},
+			},
+		},
+		{
+			// Images which are already pinned keep their digest.
+			o: &appsv1.Deployment{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Deployment",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						PinDigestAnnotation: "true",
+					},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Image: "nginx:1.14.2@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// Images which can't be pinned keep their constraint instead of a mutable tag.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:~1.13",
+						},
+					},
+				},
+			},
+			pinDigest: true,
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.13"}`,
+					},
+				},
+			},
+		},
+		{
+			// Images keep their digest while their recorded constraint resolves to the same tag.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Annotations: map[string]string{
+						ConstraintsAnnotation: `{"containers/nginx":"~1.14"}`,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.14.2@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+						},
+					},
+				},
+			},
+			pinDigest: true,
+		},
+		{
+			// Pods owned by a controller are never pinned.
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind:       "ReplicaSet",
+							Name:       "test",
+							Controller: &isController,
+						},
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.14.2@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+						},
+					},
+				},
+			},
+			pinDigest: true,
+		},
+	}
+
+	for _, test := range tests {
+		dockerClient := &testDockerClient{
+			tags: [][]string{{"1.13.0", "1.14.2"}},
+			errs: []error{nil},
+			digests: map[string]string{
+				"nginx:1.14.2": digest,
+			},
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient, WithDigestPinning(test.pinDigest))
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(test.o))
+
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, test.expected, patches)
+	}
+}
+
+type testRepositoryClient struct {
+	tags map[string][]string
+}
+
+func (c *testRepositoryClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
+	tags, exists := c.tags[repository]
+	if !exists {
+		return nil, errors.New("repository unknown")
+	}
+	return tags, nil
+}
+
+func (c *testRepositoryClient) Digest(ctx context.Context, auth *docker.Auth, repository, tag string) (string, error) {
+	return "", errors.New("manifest unknown")
+}
+
+// testConcurrentClient keeps track of how many tags are listed at once. With a barrier, listings wait until that many are in flight,
+// blocked repositories are never listed before the deadline.
+type testConcurrentClient struct {
+	testRepositoryClient
+	barrier int
+	blocked map[string]bool
+	all     chan struct{}
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *testConcurrentClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	if c.inFlight == c.barrier {
+		close(c.all)
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	if c.blocked[repository] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if c.barrier > 0 {
+		select {
+		case <-c.all:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return c.testRepositoryClient.Tags(ctx, auth, repository)
+}
+
+func TestGetPatchesConcurrent(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{
+					Name:  "busybox",
+					Image: "busybox:~1.0",
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "nginx",
+					Image: "nginx:~1.14",
+				},
+				{
+					Name:  "sidecar",
+					Image: "quay.io/sidecar:^2.0",
+				},
+				{
+					Name:  "envoy",
+					Image: "envoy:^3.0",
+				},
+			},
+		},
+	}
+
+	expected := []*JSONPatch{
+		&JSONPatch{
+			Op:    "replace",
+			Path:  "/spec/initContainers/0/image",
+			Value: "busybox:1.0.1",
+		},
+		&JSONPatch{
+			Op:    "replace",
+			Path:  "/spec/containers/0/image",
+			Value: "nginx:1.14.3",
+		},
+		&JSONPatch{
+			Op:    "replace",
+			Path:  "/spec/containers/1/image",
+			Value: "quay.io/sidecar:2.1.0",
+		},
+		&JSONPatch{
+			Op:    "replace",
+			Path:  "/spec/containers/2/image",
+			Value: "envoy:3.0.1",
+		},
+		&JSONPatch{
+			Op:   "add",
+			Path: "/metadata/annotations",
+			Value: map[string]string{
+				ConstraintsAnnotation: `{"containers/envoy":"^3.0","containers/nginx":"~1.14","containers/sidecar":"^2.0","initContainers/busybox":"~1.0"}`,
+			},
+		},
+	}
+
+	tests := []struct {
+		barrier     int
+		blocked     map[string]bool
+		timeout     time.Duration
+		concurrency int
+		expected    []*JSONPatch
+	}{
+		{
+			// Every image has to be listed at once for any of them to be resolved before the deadline.
+			barrier:     4,
+			timeout:     10 * time.Second,
+			concurrency: 4,
+			expected:    expected,
+		},
+		{
+			// Timeouts which aren't positive use the default rather than an expired deadline.
+			timeout:     0,
+			concurrency: 1,
+			expected:    expected,
+		},
+		{
+			// Images which aren't resolved before the deadline are left untouched.
+			blocked: map[string]bool{
+				"nginx": true,
+			},
+			timeout:     500 * time.Millisecond,
+			concurrency: 2,
+			expected: []*JSONPatch{
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/initContainers/0/image",
+					Value: "busybox:1.0.1",
+				},
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/containers/1/image",
+					Value: "quay.io/sidecar:2.1.0",
+				},
+				&JSONPatch{
+					Op:    "replace",
+					Path:  "/spec/containers/2/image",
+					Value: "envoy:3.0.1",
+				},
+				&JSONPatch{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]string{
+						ConstraintsAnnotation: `{"containers/envoy":"^3.0","containers/nginx":"~1.14","containers/sidecar":"^2.0","initContainers/busybox":"~1.0"}`,
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		dockerClient := &testConcurrentClient{
+			testRepositoryClient: testRepositoryClient{
+				tags: map[string][]string{
+					"busybox":         {"1.0.0", "1.0.1"},
+					"nginx":           {"1.14.2", "1.14.3"},
+					"quay.io/sidecar": {"2.0.0", "2.1.0"},
+					"envoy":           {"3.0.0", "3.0.1"},
+				},
+			},
+			barrier: test.barrier,
+			blocked: test.blocked,
+			all:     make(chan struct{}),
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient, WithResolveTimeout(test.timeout), WithConcurrency(test.concurrency))
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, patches)
+		assert.True(t, dockerClient.maxInFlight <= test.concurrency, "%d concurrent lookups", dockerClient.maxInFlight)
+		if test.barrier > 0 {
+			assert.Equal(t, test.barrier, dockerClient.maxInFlight)
+		}
+	}
+}
+
+type testCredentialClient struct {
+	errs  map[string]error
+	calls []string
+}
+
+func (c *testCredentialClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
+	var username string
+	if auth != nil {
+		username = auth.Username
+	}
+	c.calls = append(c.calls, username)
+	if err, exists := c.errs[username]; exists {
+		return nil, err
+	}
+	return []string{"1.0.0", "1.1.0"}, nil
+}
+
+func (c *testCredentialClient) Digest(ctx context.Context, auth *docker.Auth, repository, tag string) (string, error) {
+	return "", errors.New("manifest unknown")
+}
+
+type testCredentialSource struct {
+	authentication *docker.Auth
+}
+
+func (s *testCredentialSource) Credentials(ctx context.Context, ref *docker.Reference) (*docker.Auth, error) {
+	if s.authentication == nil {
+		return nil, errors.New("no credentials")
+	}
+	return s.authentication, nil
+}
+
+func TestGetPatchesCredentialStrategy(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			ImagePullSecrets: []corev1.LocalObjectReference{
+				{
+					Name: "quay",
+				},
+				{
+					Name: "a",
+				},
+				{
+					Name: "duplicate",
+				},
+				{
+					Name: "b",
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "nginx",
+					Image: "nginx:^1.0",
+				},
+			},
+		},
+	}
+
+	secretRetriever := &testSecretRetriever{
+		secrets: map[string]*corev1.Secret{
+			"quay":      newDockerSecret(corev1.DockerConfigJsonKey, "quay.io", "cXVheTpwYXNz"), // quay:pass
+			"a":         newDockerSecret(corev1.DockerConfigJsonKey, "docker.io", "YTpwYXNz"),   // a:pass
+			"duplicate": newDockerSecret(corev1.DockerConfigJsonKey, "docker.io", "YTpwYXNz"),   // a:pass
+			"b":         newDockerSecret(corev1.DockerConfigJsonKey, "docker.io", "YjpwYXNz"),   // b:pass
+		},
+		namespace: "default",
+	}
+
+	resolved := &JSONPatch{
+		Op:    "replace",
+		Path:  "/spec/containers/0/image",
+		Value: "nginx:1.1.0",
+	}
+	recorded := &JSONPatch{
+		Op:   "add",
+		Path: "/metadata/annotations",
+		Value: map[string]string{
+			ConstraintsAnnotation: `{"containers/nginx":"^1.0"}`,
+		},
+	}
+	unauthorized := errors.New("unauthorized")
+
+	tests := []struct {
+		strategy      CredentialStrategy
+		sources       []docker.CredentialSource
+		errs          map[string]error
+		expectedCalls []string
+		expected      []*JSONPatch
+	}{
+		{
+			strategy:      AuthFirst,
+			expectedCalls: []string{"a"},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+		{
+			strategy:      AuthFirst,
+			errs:          map[string]error{"a": unauthorized},
+			expectedCalls: []string{"a", "b"},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+		{
+			strategy:      AuthFirst,
+			errs:          map[string]error{"a": unauthorized, "b": unauthorized},
+			expectedCalls: []string{"a", "b", ""},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+		{
+			strategy:      AnonymousFirst,
+			expectedCalls: []string{""},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+		{
+			strategy:      AnonymousFirst,
+			errs:          map[string]error{"": unauthorized},
+			expectedCalls: []string{"", "a"},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+		{
+			strategy:      AuthOnly,
+			errs:          map[string]error{"a": unauthorized, "b": unauthorized},
+			expectedCalls: []string{"a", "b"},
+			expected:      []*JSONPatch{recorded},
+		},
+		{
+			// Credential sources follow the image pull secrets, repeated credentials are only tried once.
+			strategy: AuthOnly,
+			sources: []docker.CredentialSource{
+				&testCredentialSource{},
+				&testCredentialSource{authentication: &docker.Auth{Username: "b", Password: "pass"}},
+				&testCredentialSource{authentication: &docker.Auth{Username: "node", Password: "pass"}},
+			},
+			errs:          map[string]error{"a": unauthorized, "b": unauthorized},
+			expectedCalls: []string{"a", "b", "node"},
+			expected:      []*JSONPatch{resolved, recorded},
+		},
+	}
+
+	for _, test := range tests {
+		dockerClient := &testCredentialClient{errs: test.errs}
+		w := New(secretRetriever, version.NewSemVersionResolver(), dockerClient, WithCredentialStrategy(test.strategy), WithCredentialSources(test.sources...))
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedCalls, dockerClient.calls, string(test.strategy))
+		assert.Equal(t, test.expected, patches, string(test.strategy))
+	}
+}
+
+type testPagedClient struct {
+	pages  [][]string
+	listed int
+}
+
+func (c *testPagedClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
+	return c.TagsUntil(ctx, auth, repository, nil)
+}
+
+func (c *testPagedClient) TagsUntil(ctx context.Context, auth *docker.Auth, repository string, done func(tags []string) bool) ([]string, error) {
+	var tags []string
+	for _, page := range c.pages {
+		tags = append(tags, page...)
+		c.listed = len(tags)
+		if done != nil && done(tags) {
+			break
+		}
+	}
+	return tags, nil
+}
+
+func (c *testPagedClient) Digest(ctx context.Context, auth *docker.Auth, repository, tag string) (string, error) {
+	return "", errors.New("manifest unknown")
+}
+
+func TestGetPatchesPagedTags(t *testing.T) {
+	pages := [][]string{{"1.3.0", "1.4.0"}, {"1.4.1", "1.4.2"}, {"1.5.0", "2.0.0"}}
+
+	tests := []struct {
+		constraint string
+		expected   string
+		listed     int
+	}{
+		{
+			// No tag after 1.4.2 could satisfy the constraint.
+			constraint: "<=1.4.2",
+			expected:   "nginx:1.4.2",
+			listed:     4,
+		},
+		{
+			constraint: "~1.4",
+			expected:   "nginx:1.4.2",
+			listed:     6,
+		},
+		{
+			constraint: "^1.0",
+			expected:   "nginx:1.5.0",
+			listed:     6,
+		},
+	}
+
+	for _, test := range tests {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "default",
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "nginx",
+						Image: "nginx:" + test.constraint,
+					},
+				},
+			},
+		}
+
+		dockerClient := &testPagedClient{pages: pages}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient)
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+		assert.NoError(t, err, test.constraint)
+		if assert.NotEmpty(t, patches, test.constraint) {
+			assert.Equal(t, test.expected, patches[0].Value, test.constraint)
+		}
+		assert.Equal(t, test.listed, dockerClient.listed, test.constraint)
+	}
+}
+
+func TestRequiredPlatforms(t *testing.T) {
+	amd64 := docker.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := docker.Platform{OS: "linux", Architecture: "arm64"}
+	windows := docker.Platform{OS: "windows", Architecture: "amd64"}
+
+	archAffinity := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+		return &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			},
+		}
+	}
+	archTerm := func(operator corev1.NodeSelectorOperator, values ...string) corev1.NodeSelectorTerm {
+		return corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: operator, Values: values}},
+		}
+	}
+	zoneTerm := corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}},
+	}
+
+	tests := []struct {
+		name     string
+		podSpec  corev1.PodSpec
+		defaults []docker.Platform
+		expected []docker.Platform
+	}{
+		{
+			name: "unconstrained without defaults",
+		},
+		{
+			name:     "unconstrained with defaults",
+			defaults: []docker.Platform{amd64, arm64, windows},
+			expected: []docker.Platform{amd64, arm64},
+		},
+		{
+			name:     "node selector",
+			podSpec:  corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"}},
+			defaults: []docker.Platform{amd64},
+			expected: []docker.Platform{arm64},
+		},
+		{
+			name:     "beta node selector",
+			podSpec:  corev1.PodSpec{NodeSelector: map[string]string{"beta.kubernetes.io/arch": "arm64"}},
+			expected: []docker.Platform{arm64},
+		},
+		{
+			name:     "windows node selector",
+			podSpec:  corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/os": "windows"}},
+			defaults: []docker.Platform{amd64, arm64, windows},
+			expected: []docker.Platform{windows},
+		},
+		{
+			name:     "affinity",
+			podSpec:  corev1.PodSpec{Affinity: archAffinity(archTerm(corev1.NodeSelectorOpIn, "arm64", "amd64"))},
+			expected: []docker.Platform{amd64, arm64},
+		},
+		{
+			name:     "affinity terms are ORed",
+			podSpec:  corev1.PodSpec{Affinity: archAffinity(archTerm(corev1.NodeSelectorOpIn, "arm64"), archTerm(corev1.NodeSelectorOpIn, "amd64"))},
+			expected: []docker.Platform{amd64, arm64},
+		},
+		{
+			name:     "affinity term without architecture",
+			podSpec:  corev1.PodSpec{Affinity: archAffinity(archTerm(corev1.NodeSelectorOpIn, "arm64"), zoneTerm)},
+			defaults: []docker.Platform{amd64},
+			expected: []docker.Platform{amd64},
+		},
+		{
+			name:     "affinity not in",
+			podSpec:  corev1.PodSpec{Affinity: archAffinity(archTerm(corev1.NodeSelectorOpNotIn, "arm64"))},
+			defaults: []docker.Platform{amd64, arm64},
+			expected: []docker.Platform{amd64},
+		},
+		{
+			name:    "affinity not in without defaults",
+			podSpec: corev1.PodSpec{Affinity: archAffinity(archTerm(corev1.NodeSelectorOpNotIn, "arm64"))},
+		},
+		{
+			name: "node selector and affinity",
+			podSpec: corev1.PodSpec{
+				NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"},
+				Affinity:     archAffinity(archTerm(corev1.NodeSelectorOpIn, "arm64", "amd64")),
+			},
+			expected: []docker.Platform{arm64},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, requiredPlatforms(&test.podSpec, test.defaults), test.name)
+	}
+}
+
+type testPlatformClient struct {
+	testRepositoryClient
+	platforms map[string][]docker.Platform
+	inspected []string
+}
+
+func (c *testPlatformClient) Platforms(ctx context.Context, auth *docker.Auth, repository, tag string) ([]docker.Platform, error) {
+	c.inspected = append(c.inspected, tag)
+	platforms, exists := c.platforms[tag]
+	if !exists {
+		return nil, errors.New("manifest unknown")
+	}
+	return platforms, nil
+}
+
+func TestGetPatchesPlatforms(t *testing.T) {
+	amd64 := docker.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := docker.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+	platforms := map[string][]docker.Platform{
+		"1.0.0": {amd64, arm64},
+		"1.1.0": {amd64, arm64},
+		"1.2.0": {amd64},
+	}
+
+	tests := []struct {
+		name      string
+		defaults  []docker.Platform
+		selector  map[string]string
+		expected  string
+		inspected []string
+	}{
+		{
+			name:     "no platforms required",
+			expected: "nginx:1.2.0",
+		},
+		{
+			name:      "default platforms",
+			defaults:  []docker.Platform{amd64, {OS: "linux", Architecture: "arm64"}},
+			expected:  "nginx:1.1.0",
+			inspected: []string{"1.2.0", "1.1.0"},
+		},
+		{
+			name:      "node selector",
+			selector:  map[string]string{"kubernetes.io/arch": "amd64"},
+			expected:  "nginx:1.2.0",
+			inspected: []string{"1.2.0"},
+		},
+		{
+			name:      "default platform with variant",
+			defaults:  []docker.Platform{{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+			expected:  "nginx:1.1.0",
+			inspected: []string{"1.2.0", "1.1.0"},
+		},
+		{
+			name:      "unpublished architecture",
+			selector:  map[string]string{"kubernetes.io/arch": "s390x"},
+			inspected: []string{"1.2.0", "1.1.0", "1.0.0"},
+		},
+	}
+
+	for _, test := range tests {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "default",
+			},
+			Spec: corev1.PodSpec{
+				NodeSelector: test.selector,
+				Containers: []corev1.Container{
+					{
+						Name:  "nginx",
+						Image: "nginx:^1.0",
+					},
+				},
+			},
+		}
+
+		dockerClient := &testPlatformClient{
+			testRepositoryClient: testRepositoryClient{
+				tags: map[string][]string{"nginx": {"1.0.0", "1.1.0", "1.2.0"}},
+			},
+			platforms: platforms,
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient, WithPlatforms(test.defaults...))
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+		assert.NoError(t, err, test.name)
+
+		var image interface{}
+		for _, patch := range patches {
+			if patch.Path == "/spec/containers/0/image" {
+				image = patch.Value
+			}
+		}
+		if test.expected == "" {
+			assert.Nil(t, image, test.name)
+		} else {
+			assert.Equal(t, test.expected, image, test.name)
+		}
+		assert.Equal(t, test.inspected, dockerClient.inspected, test.name)
+	}
+}
+
+type testCreatedClient struct {
+	testRepositoryClient
+	created   map[string]time.Time
+	inspected []string
+}
+
+func (c *testCreatedClient) Created(ctx context.Context, auth *docker.Auth, repository, tag string) (time.Time, error) {
+	c.inspected = append(c.inspected, tag)
+	created, exists := c.created[tag]
+	if !exists {
+		return time.Time{}, errors.New("manifest unknown")
+	}
+	return created, nil
+}
+
+func TestGetPatchesMinimumAge(t *testing.T) {
+	now := time.Now()
+
+	created := map[string]time.Time{
+		"1.0.0": now.Add(-30 * 24 * time.Hour),
+		"1.1.0": now.Add(-48 * time.Hour),
+		"1.2.0": now.Add(-10 * time.Minute),
+	}
+
+	tests := []struct {
+		name        string
+		minAge      time.Duration
+		annotations map[string]string
+		expected    string
+		inspected   []string
+	}{
+		{
+			name:     "no minimum age",
+			expected: "nginx:1.2.0",
+		},
+		{
+			name:      "minimum age",
+			minAge:    24 * time.Hour,
+			expected:  "nginx:1.1.0",
+			inspected: []string{"1.2.0", "1.1.0"},
+		},
+		{
+			name:        "annotation",
+			annotations: map[string]string{MinAgeAnnotation: "168h"},
+			expected:    "nginx:1.0.0",
+			inspected:   []string{"1.2.0", "1.1.0", "1.0.0"},
+		},
+		{
+			name:        "annotation disables the minimum age",
+			minAge:      24 * time.Hour,
+			annotations: map[string]string{MinAgeAnnotation: "0"},
+			expected:    "nginx:1.2.0",
+		},
+		{
+			name:        "invalid annotation",
+			minAge:      24 * time.Hour,
+			annotations: map[string]string{MinAgeAnnotation: "7d"},
+			expected:    "nginx:1.1.0",
+			inspected:   []string{"1.2.0", "1.1.0"},
+		},
+		{
+			name:      "every tag too young",
+			minAge:    365 * 24 * time.Hour,
+			inspected: []string{"1.2.0", "1.1.0", "1.0.0"},
+		},
+	}
+
+	for _, test := range tests {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: test.annotations,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "nginx",
+						Image: "nginx:^1.0",
+					},
+				},
+			},
+		}
+
+		dockerClient := &testCreatedClient{
+			testRepositoryClient: testRepositoryClient{
+				tags: map[string][]string{"nginx": {"1.0.0", "1.1.0", "1.2.0"}},
+			},
+			created: created,
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient, WithMinimumAge(test.minAge))
+
+		patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+		assert.NoError(t, err, test.name)
+
+		var image interface{}
+		for _, patch := range patches {
+			if patch.Path == "/spec/containers/0/image" {
+				image = patch.Value
+			}
+		}
+		if test.expected == "" {
+			assert.Nil(t, image, test.name)
+		} else {
+			assert.Equal(t, test.expected, image, test.name)
+		}
+		assert.Equal(t, test.inspected, dockerClient.inspected, test.name)
+	}
+}
+
+// testFailingResolver resolves once and fails without a result afterwards.
+type testFailingResolver struct {
+	version.Resolver
+	resolved bool
+}
+
+func (r *testFailingResolver) Resolve(constraint string, tags []string) (*version.Result, error) {
+	if r.resolved {
+		return nil, errors.New("resolver unavailable")
+	}
+	r.resolved = true
+	return r.Resolver.Resolve(constraint, tags)
+}
+
+func TestGetPatchesResolverWithoutResult(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "nginx",
+					Image: "nginx:^1.0",
+				},
+			},
+		},
+	}
+
+	dockerClient := &testCreatedClient{
+		testRepositoryClient: testRepositoryClient{
+			tags: map[string][]string{"nginx": {"1.0.0", "1.1.0"}},
+		},
+		created: map[string]time.Time{
+			"1.0.0": time.Now().Add(-48 * time.Hour),
+			"1.1.0": time.Now(),
+		},
+	}
+	resolver := &testFailingResolver{Resolver: version.NewSemVersionResolver()}
+	w := New(&testSecretRetriever{}, resolver, dockerClient, WithMinimumAge(24*time.Hour))
+
+	// Resolving again after rejecting the too young 1.1.0 fails without a result, which leaves the image untouched.
+	patches, err := w.GetPatches(context.Background(), createAdmissionRequest(pod))
+
+	assert.NoError(t, err)
+	for _, patch := range patches {
+		assert.NotEqual(t, "/spec/containers/0/image", patch.Path)
+	}
+}
+
+type testRecordingClient struct {
+	testRepositoryClient
+	requested []string
+}
+
+func (c *testRecordingClient) Tags(ctx context.Context, auth *docker.Auth, repository string) ([]string, error) {
+	c.requested = append(c.requested, repository)
+	return c.testRepositoryClient.Tags(ctx, auth, repository)
+}
+
+func TestGetPatchesSelection(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []Option
+		namespace   string
+		annotations map[string]string
+		expected    map[string]interface{}
+		requested   []string
+	}{
+		{
+			name:      "enabled by default",
+			namespace: "default",
+			expected:  map[string]interface{}{"/spec/containers/0/image": "nginx:1.15.0", "/spec/containers/1/image": "redis:5.0.0"},
+			requested: []string{"nginx", "redis"},
+		},
+		{
+			name:        "opt out",
+			namespace:   "default",
+			annotations: map[string]string{EnabledAnnotation: "false"},
+		},
+		{
+			name:      "opt in mode without annotation",
+			options:   []Option{WithOptIn(true)},
+			namespace: "default",
+		},
+		{
+			name:        "opt in",
+			options:     []Option{WithOptIn(true)},
+			namespace:   "default",
+			annotations: map[string]string{EnabledAnnotation: "true"},
+			expected:    map[string]interface{}{"/spec/containers/0/image": "nginx:1.15.0", "/spec/containers/1/image": "redis:5.0.0"},
+			requested:   []string{"nginx", "redis"},
+		},
+		{
+			name:        "ignored container",
+			namespace:   "default",
+			annotations: map[string]string{IgnoreContainersAnnotation: "redis, sidecar"},
+			expected:    map[string]interface{}{"/spec/containers/0/image": "nginx:1.15.0"},
+			requested:   []string{"nginx"},
+		},
+		{
+			name:        "every container ignored",
+			namespace:   "default",
+			annotations: map[string]string{IgnoreContainersAnnotation: "nginx,redis"},
+		},
+		{
+			name:        "kube-system",
+			namespace:   "kube-system",
+			options:     []Option{WithIncludedNamespaces("kube-system")},
+			annotations: map[string]string{EnabledAnnotation: "true"},
+		},
+		{
+			name:      "included namespace",
+			options:   []Option{WithIncludedNamespaces("apps", "default")},
+			namespace: "default",
+			expected:  map[string]interface{}{"/spec/containers/0/image": "nginx:1.15.0", "/spec/containers/1/image": "redis:5.0.0"},
+			requested: []string{"nginx", "redis"},
+		},
+		{
+			name:      "namespace not included",
+			options:   []Option{WithIncludedNamespaces("apps")},
+			namespace: "default",
+		},
+		{
+			name:      "excluded namespace",
+			options:   []Option{WithIncludedNamespaces("default"), WithExcludedNamespaces("default")},
+			namespace: "default",
+		},
+		{
+			name:      "namespace of the request",
+			options:   []Option{WithExcludedNamespaces("default")},
+			namespace: "",
+		},
+	}
+
+	for _, test := range tests {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   test.namespace,
+				Annotations: test.annotations,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "nginx",
+						Image: "nginx:^1.14",
+					},
+					{
+						Name:  "redis",
+						Image: "redis:^5.0",
+					},
+				},
+			},
+		}
+
+		dockerClient := &testRecordingClient{
+			testRepositoryClient: testRepositoryClient{
+				tags: map[string][]string{
+					"nginx": {"1.14.0", "1.15.0"},
+					"redis": {"5.0.0"},
+				},
+			},
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient, test.options...)
+
+		ar := createAdmissionRequest(pod)
+		ar.Namespace = "default"
+
+		patches, err := w.GetPatches(context.Background(), ar)
+
+		assert.NoError(t, err, test.name)
+
+		images := map[string]interface{}{}
+		for _, patch := range patches {
+			if strings.HasSuffix(patch.Path, "/image") {
+				images[patch.Path] = patch.Value
+			}
+		}
+		if test.expected == nil {
+			assert.Empty(t, images, test.name)
+		} else {
+			assert.Equal(t, test.expected, images, test.name)
+		}
+		assert.Equal(t, test.requested, dockerClient.requested, test.name)
+	}
+}
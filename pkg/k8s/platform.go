@@ -0,0 +1,192 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jw-s/updatey/pkg/client/docker"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	archLabel     = "kubernetes.io/arch"
+	betaArchLabel = "beta.kubernetes.io/arch"
+	osLabel       = "kubernetes.io/os"
+	betaOSLabel   = "beta.kubernetes.io/os"
+	defaultOS     = "linux"
+)
+
+// requiredPlatforms returns the platforms an image has to publish for the pod to run on every node it may be scheduled to,
+// as implied by the kubernetes.io/arch label in its node selector and required node affinity. Pods which don't constrain their
+// architecture require the default platforms of their operating system, no platforms are required when there are none.
+func requiredPlatforms(podSpec *corev1.PodSpec, defaults []docker.Platform) []docker.Platform {
+	os := defaultOS
+	for _, label := range []string{betaOSLabel, osLabel} {
+		if value, exists := podSpec.NodeSelector[label]; exists {
+			os = value
+		}
+	}
+
+	var defaultArchitectures []string
+	var platforms []docker.Platform
+	for _, platform := range defaults {
+		if platform.OS == os {
+			defaultArchitectures = append(defaultArchitectures, platform.Architecture)
+			platforms = append(platforms, platform)
+		}
+	}
+
+	architectures := nodeArchitectures(podSpec, defaultArchitectures)
+	if architectures == nil {
+		return platforms
+	}
+
+	platforms = nil
+	for _, architecture := range architectures {
+		platforms = append(platforms, docker.Platform{OS: os, Architecture: architecture})
+	}
+	return platforms
+}
+
+// nodeArchitectures returns the architectures of the nodes the pod may be scheduled to, nil when it isn't constrained.
+// NotIn expressions exclude architectures from the defaults, they don't constrain the pod when there are no defaults.
+func nodeArchitectures(podSpec *corev1.PodSpec, defaults []string) []string {
+	var allowed map[string]bool
+
+	for _, label := range []string{betaArchLabel, archLabel} {
+		if value, exists := podSpec.NodeSelector[label]; exists {
+			allowed = intersect(allowed, map[string]bool{value: true})
+		}
+	}
+
+	if affinity := podSpec.Affinity; affinity != nil && affinity.NodeAffinity != nil && affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		// Terms are ORed, so the pod is only constrained when every term is.
+		var union map[string]bool
+		constrained := true
+
+		for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			termAllowed := termArchitectures(term, defaults)
+			if termAllowed == nil {
+				constrained = false
+				break
+			}
+			if union == nil {
+				union = map[string]bool{}
+			}
+			for architecture := range termAllowed {
+				union[architecture] = true
+			}
+		}
+
+		if constrained && union != nil {
+			allowed = intersect(allowed, union)
+		}
+	}
+
+	if allowed == nil {
+		return nil
+	}
+
+	architectures := []string{}
+	for architecture := range allowed {
+		architectures = append(architectures, architecture)
+	}
+	sort.Strings(architectures)
+	return architectures
+}
+
+// termArchitectures returns the architectures a node selector term allows, nil when it doesn't constrain the architecture.
+func termArchitectures(term corev1.NodeSelectorTerm, defaults []string) map[string]bool {
+	var allowed map[string]bool
+
+	for _, expression := range term.MatchExpressions {
+		if expression.Key != archLabel && expression.Key != betaArchLabel {
+			continue
+		}
+
+		switch expression.Operator {
+		case corev1.NodeSelectorOpIn:
+			values := map[string]bool{}
+			for _, value := range expression.Values {
+				values[value] = true
+			}
+			allowed = intersect(allowed, values)
+		case corev1.NodeSelectorOpNotIn:
+			if len(defaults) == 0 {
+				continue
+			}
+			values := map[string]bool{}
+			for _, architecture := range defaults {
+				values[architecture] = true
+			}
+			for _, value := range expression.Values {
+				delete(values, value)
+			}
+			allowed = intersect(allowed, values)
+		}
+	}
+
+	return allowed
+}
+
+// intersect returns the intersection of two sets, a nil set stands for every value.
+func intersect(a, b map[string]bool) map[string]bool {
+	if a == nil {
+		return b
+	}
+
+	intersection := map[string]bool{}
+	for value := range a {
+		if b[value] {
+			intersection[value] = true
+		}
+	}
+	return intersection
+}
+
+// platformCheck rejects tags which don't publish every required platform of the image, nil when there is nothing to check.
+func (w *Wrapper) platformCheck(authentication *docker.Auth, containerImage *containerImage) tagCheck {
+	platformClient, ok := w.dockerClient.(docker.PlatformInterface)
+
+	if !ok || len(containerImage.platforms) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, tag string) (string, error) {
+		published, err := platformClient.Platforms(ctx, authentication, containerImage.repository, tag)
+		if err != nil {
+			return "", fmt.Errorf("unable to inspect the platforms of %s:%s: %v", containerImage.repository, tag, err)
+		}
+
+		if missing := missingPlatforms(containerImage.platforms, published); len(missing) > 0 {
+			return fmt.Sprintf("does not publish %s", platformList(missing)), nil
+		}
+		return "", nil
+	}
+}
+
+// missingPlatforms returns the required platforms none of the published ones satisfy.
+func missingPlatforms(required, published []docker.Platform) []docker.Platform {
+	var missing []docker.Platform
+
+requiredLoop:
+	for _, platform := range required {
+		for _, p := range published {
+			if p.Satisfies(platform) {
+				continue requiredLoop
+			}
+		}
+		missing = append(missing, platform)
+	}
+	return missing
+}
+
+func platformList(platforms []docker.Platform) string {
+	var names []string
+	for _, platform := range platforms {
+		names = append(names, platform.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -1,21 +1,23 @@
 package k8s
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"strings"
 
 	"github.com/golang/glog"
 
 	"github.com/jw-s/updatey/pkg/client/docker"
 
-	"github.com/docker/distribution/reference"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 // SecretInterface defines how to fetch secrets.
@@ -40,23 +42,112 @@ func NewSecretRetriever(secretsGetter v1.SecretsGetter) *SecretRetriever {
 	}
 }
 
-type registryConfigs struct {
-	Auths registryConfig `json:"auths,omitempty"`
+// dockerSecretTypes are the types of secrets which hold docker registry credentials.
+var dockerSecretTypes = []corev1.SecretType{corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg}
+
+// CachedSecretRetriever provides functionality to fetch docker registry secrets from informer caches,
+// secrets which aren't cached are fetched by the fallback.
+type CachedSecretRetriever struct {
+	factories []informers.SharedInformerFactory
+	listers   []corelisters.SecretLister
+	synced    []cache.InformerSynced
+	fallback  SecretInterface
+}
+
+// NewCachedSecretRetriever creates a new CachedSecretRetriever which watches the secrets holding docker registry credentials in every namespace.
+func NewCachedSecretRetriever(kubeClient kubernetes.Interface, fallback SecretInterface) *CachedSecretRetriever {
+	s := &CachedSecretRetriever{
+		fallback: fallback,
+	}
+
+	for _, secretType := range dockerSecretTypes {
+		fieldSelector := fields.OneTermEqualSelector("type", string(secretType)).String()
+		factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fieldSelector
+		}))
+		informer := factory.Core().V1().Secrets()
+
+		s.factories = append(s.factories, factory)
+		s.listers = append(s.listers, informer.Lister())
+		s.synced = append(s.synced, informer.Informer().HasSynced)
+	}
+
+	return s
+}
+
+// Run starts the informers and blocks until their caches are synced, until then secrets are fetched by the fallback.
+func (s *CachedSecretRetriever) Run(stopCh <-chan struct{}) error {
+	for _, factory := range s.factories {
+		factory.Start(stopCh)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, s.synced...) {
+		return errors.New("failed to wait for secret caches to sync")
+	}
+	return nil
+}
+
+// Get returns a namespaced secret of the specified name from the caches or the fallback and possibily an error.
+func (s *CachedSecretRetriever) Get(namespace, name string) (*corev1.Secret, error) {
+	for _, lister := range s.listers {
+		secret, err := lister.Secrets(namespace).Get(name)
+		if err == nil {
+			return secret, nil
+		}
+	}
+	return s.fallback.Get(namespace, name)
+}
+
+// ServiceAccountInterface defines how to fetch service accounts.
+type ServiceAccountInterface interface {
+	Get(namespace, name string) (*corev1.ServiceAccount, error)
+}
+
+// ServiceAccountRetriever provides functionality to fetch service accounts.
+type ServiceAccountRetriever struct {
+	serviceAccountsGetter v1.ServiceAccountsGetter
+}
+
+// Get returns a namespaced service account of the specified name and possibily an error.
+func (s *ServiceAccountRetriever) Get(namespace, name string) (*corev1.ServiceAccount, error) {
+	return s.serviceAccountsGetter.ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
 }
 
-type registryConfig map[string]struct {
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
-	Auth     string `json:"auth,omitempty"`
+// NewServiceAccountRetriever creates a new ServiceAccountRetriever
+func NewServiceAccountRetriever(serviceAccountsGetter v1.ServiceAccountsGetter) *ServiceAccountRetriever {
+	return &ServiceAccountRetriever{
+		serviceAccountsGetter: serviceAccountsGetter,
+	}
+}
+
+type registryConfigs struct {
+	Auths docker.Config `json:"auths,omitempty"`
 }
 
 // GetImagePullSecrets returns a slice of secrets for and possibily an error.
-func (w *Wrapper) GetImagePullSecrets(imagePullSecrets []corev1.LocalObjectReference, namespace string) (secrets []*corev1.Secret, err error) {
+// The image pull secrets of the service account, "default" when no name is given, follow the ones of the pod as the kubelet would use them too.
+// Secrets which weren't retrieved before the context is done are skipped.
+func (w *Wrapper) GetImagePullSecrets(ctx context.Context, imagePullSecrets []corev1.LocalObjectReference, serviceAccountName, namespace string) (secrets []*corev1.Secret, err error) {
 	if namespace == "" {
 		namespace = "default"
 	}
 
+	// The secrets of the pod spec are copied, appending to them could otherwise write into the pod spec's spare capacity.
+	imagePullSecrets = append(append([]corev1.LocalObjectReference(nil), imagePullSecrets...), w.serviceAccountImagePullSecrets(serviceAccountName, namespace)...)
+
+	seen := map[string]bool{}
+
 	for _, pullSecret := range imagePullSecrets {
+		if ctx.Err() != nil {
+			glog.Errorf("retrieving image pull secrets stopped: %v", ctx.Err())
+			break
+		}
+
+		if seen[pullSecret.Name] {
+			continue
+		}
+		seen[pullSecret.Name] = true
+
 		secret, err := w.secretRetriever.Get(namespace, pullSecret.Name)
 
 		if err != nil {
@@ -72,8 +163,30 @@ func (w *Wrapper) GetImagePullSecrets(imagePullSecrets []corev1.LocalObjectRefer
 
 }
 
-// ExtractFromDockerSecret returns a username and password for a docker registry from the secret and possibly an error.
-func ExtractFromDockerSecret(secret *corev1.Secret, image string) (username, password string, err error) {
+// serviceAccountImagePullSecrets returns the image pull secrets of the service account, missing service accounts have none.
+func (w *Wrapper) serviceAccountImagePullSecrets(serviceAccountName, namespace string) []corev1.LocalObjectReference {
+	if w.serviceAccountRetriever == nil {
+		return nil
+	}
+
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+
+	serviceAccount, err := w.serviceAccountRetriever.Get(namespace, serviceAccountName)
+
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			glog.Error(err)
+		}
+		return nil
+	}
+	return serviceAccount.ImagePullSecrets
+}
+
+// ExtractFromDockerSecret returns the credentials for the docker registry of the image from the secret and possibly an error.
+// Registries are matched like the kubelet does, see docker.Config.
+func ExtractFromDockerSecret(secret *corev1.Secret, image string) (*docker.Auth, error) {
 
 	var registryConfigs registryConfigs
 	config, exists := secret.Data[corev1.DockerConfigJsonKey]
@@ -82,52 +195,24 @@ func ExtractFromDockerSecret(secret *corev1.Secret, image string) (username, pas
 		config, exists = secret.Data[corev1.DockerConfigKey]
 
 		if !exists {
-			err = errors.New("no docker config found in secret")
-			return username, password, err
+			return nil, errors.New("no docker config found in secret")
 		}
-		var registryConfig registryConfig
-		if err = json.Unmarshal(config, &registryConfig); err != nil {
-			return username, password, err
+		var registryConfig docker.Config
+		if err := json.Unmarshal(config, &registryConfig); err != nil {
+			return nil, err
 		}
 
 		registryConfigs.Auths = registryConfig
 	} else {
-		if err = json.Unmarshal(config, &registryConfigs); err != nil {
-			return username, password, err
+		if err := json.Unmarshal(config, &registryConfigs); err != nil {
+			return nil, err
 		}
 	}
-	base, _, err := docker.Split(image)
+	ref, err := docker.ParseReference(image)
 
 	if err != nil {
-		return username, password, err
+		return nil, err
 	}
 
-	imageName, err := reference.ParseNormalizedNamed(base)
-
-	if err != nil {
-		return username, password, err
-	}
-
-	registryDomain := reference.Domain(imageName)
-
-	registryConfig, exists := registryConfigs.Auths[registryDomain]
-
-	if !exists {
-		return username, password, fmt.Errorf("registry domain: %s does not exist", registryDomain)
-	}
-
-	b, err := base64.StdEncoding.DecodeString(registryConfig.Auth)
-
-	if err != nil {
-		return username, password, err
-	}
-
-	basicAuth := strings.Split(string(b), ":")
-
-	if len(basicAuth) != 2 {
-		return username, password, errors.New("auth field should equal basic auth syntax")
-	}
-
-	return basicAuth[0], basicAuth[1], nil
-
+	return registryConfigs.Auths.Lookup(ref)
 }
@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
@@ -9,6 +10,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const (
@@ -35,6 +37,23 @@ func (s *testSecretRetriever) Get(namespace, name string) (*corev1.Secret, error
 	return secret, s.errs[name]
 }
 
+type testServiceAccountRetriever struct {
+	serviceAccounts map[string]*corev1.ServiceAccount
+	namespace       string
+}
+
+func (s *testServiceAccountRetriever) Get(namespace, name string) (*corev1.ServiceAccount, error) {
+	serviceAccount, exists := s.serviceAccounts[name]
+	if !exists || namespace != s.namespace {
+		return nil, &apierrors.StatusError{
+			ErrStatus: metav1.Status{
+				Reason: metav1.StatusReasonNotFound,
+			},
+		}
+	}
+	return serviceAccount, nil
+}
+
 func TestExtractFromDockerSecret(t *testing.T) {
 	tests := []struct {
 		secretType   string
@@ -81,30 +100,84 @@ func TestExtractFromDockerSecret(t *testing.T) {
 			image:      "quay.io/alpine:latest",
 			auth:       base64EncodedCredentials,
 			expectErr:  true, // No valid image secret for quay.io
+		}, {
+			secretType:   corev1.DockerConfigJsonKey,
+			domain:       "registry.local:5000",
+			image:        "registry.local:5000/app:>=1.2, <2",
+			auth:         base64EncodedCredentials,
+			expectedUser: testUser,
+			expectedPass: testPass,
+		},
+		{
+			secretType:   corev1.DockerConfigJsonKey,
+			domain:       "docker.io",
+			image:        "alpine@sha256:2e2e8e2fa9f9b6cb2fc2b9c1c1e4e5d4d0d8ab1e1f1c1b1a191817161514131211",
+			auth:         base64EncodedCredentials,
+			expectedUser: testUser,
+			expectedPass: testPass,
+		},
+		{
+			secretType:   corev1.DockerConfigKey,
+			domain:       "https://index.docker.io/v1/",
+			image:        "alpine:~3.9",
+			auth:         base64EncodedCredentials,
+			expectedUser: testUser,
+			expectedPass: testPass,
+		},
+		{
+			secretType:   corev1.DockerConfigJsonKey,
+			domain:       "*.azurecr.io",
+			image:        "team.azurecr.io/app:^1.0",
+			auth:         base64EncodedCredentials,
+			expectedUser: testUser,
+			expectedPass: testPass,
+		},
+		{
+			secretType:   corev1.DockerConfigJsonKey,
+			domain:       "docker.io",
+			image:        "alpine",
+			auth:         "dGVzdHVzZXI6cGE6c3M=", // testuser:pa:ss
+			expectedUser: testUser,
+			expectedPass: "pa:ss",
+		},
+		{
+			secretType: corev1.DockerConfigJsonKey,
+			domain:     "docker.io",
+			image:      "alpine",
+			auth:       "dGVzdHVzZXI=", // testuser
+			expectErr:  true,
 		},
 	}
 
 	for _, test := range tests {
 		secret := newDockerSecret(test.secretType, test.domain, test.auth)
-		username, password, err := ExtractFromDockerSecret(secret, test.image)
+		authentication, err := ExtractFromDockerSecret(secret, test.image)
 
-		if err != nil && !test.expectErr {
+		if test.expectErr {
+			assert.Error(t, err, test.image)
+			assert.Nil(t, authentication, test.image)
+			continue
+		}
+
+		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, test.expectedUser, username)
-		assert.Equal(t, test.expectedPass, password)
+		assert.Equal(t, test.expectedUser, authentication.Username)
+		assert.Equal(t, test.expectedPass, authentication.Password)
 
 	}
 }
 
 func TestGetImagePullSecrets(t *testing.T) {
 	tests := []struct {
-		secretRetriever  *testSecretRetriever
-		imagePullSecrets []corev1.LocalObjectReference
-		namespace        string
-		expected         []*corev1.Secret
-		err              error
+		secretRetriever         *testSecretRetriever
+		serviceAccountRetriever *testServiceAccountRetriever
+		imagePullSecrets        []corev1.LocalObjectReference
+		serviceAccountName      string
+		namespace               string
+		expected                []*corev1.Secret
+		err                     error
 	}{
 		{
 			secretRetriever: &testSecretRetriever{
@@ -206,15 +279,198 @@ func TestGetImagePullSecrets(t *testing.T) {
 				},
 			},
 		},
+		{
+			// The secrets of the default service account follow the ones of the pod.
+			secretRetriever: &testSecretRetriever{
+				secrets: map[string]*corev1.Secret{
+					"mysecret": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "mysecret",
+						},
+					},
+					"registry": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "registry",
+						},
+					},
+				},
+				namespace: "team",
+			},
+			serviceAccountRetriever: &testServiceAccountRetriever{
+				serviceAccounts: map[string]*corev1.ServiceAccount{
+					"default": &corev1.ServiceAccount{
+						ImagePullSecrets: []corev1.LocalObjectReference{
+							{
+								Name: "registry",
+							},
+						},
+					},
+				},
+				namespace: "team",
+			},
+			imagePullSecrets: []corev1.LocalObjectReference{
+				{
+					Name: "mysecret",
+				},
+			},
+			namespace: "team",
+			expected: []*corev1.Secret{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "mysecret",
+					},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "registry",
+					},
+				},
+			},
+		},
+		{
+			// Secrets referenced by both the pod and its service account are only retrieved once.
+			secretRetriever: &testSecretRetriever{
+				secrets: map[string]*corev1.Secret{
+					"mysecret": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "mysecret",
+						},
+					},
+					"registry": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "registry",
+						},
+					},
+				},
+				namespace: "team",
+			},
+			serviceAccountRetriever: &testServiceAccountRetriever{
+				serviceAccounts: map[string]*corev1.ServiceAccount{
+					"builder": &corev1.ServiceAccount{
+						ImagePullSecrets: []corev1.LocalObjectReference{
+							{
+								Name: "mysecret",
+							},
+							{
+								Name: "registry",
+							},
+						},
+					},
+				},
+				namespace: "team",
+			},
+			imagePullSecrets: []corev1.LocalObjectReference{
+				{
+					Name: "mysecret",
+				},
+			},
+			serviceAccountName: "builder",
+			namespace:          "team",
+			expected: []*corev1.Secret{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "mysecret",
+					},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "registry",
+					},
+				},
+			},
+		},
+		{
+			// Missing service accounts don't contribute any secrets.
+			secretRetriever: &testSecretRetriever{
+				secrets: map[string]*corev1.Secret{
+					"mysecret": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "mysecret",
+						},
+					},
+					"registry": &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "registry",
+						},
+					},
+				},
+				namespace: "team",
+			},
+			serviceAccountRetriever: &testServiceAccountRetriever{
+				namespace: "team",
+			},
+			imagePullSecrets: []corev1.LocalObjectReference{
+				{
+					Name: "mysecret",
+				},
+			},
+			serviceAccountName: "builder",
+			namespace:          "team",
+			expected: []*corev1.Secret{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "mysecret",
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
-		w := New(test.secretRetriever, nil, nil)
+		var opts []Option
+		if test.serviceAccountRetriever != nil {
+			opts = append(opts, WithServiceAccounts(test.serviceAccountRetriever))
+		}
+		w := New(test.secretRetriever, nil, nil, opts...)
 
-		secrets, err := w.GetImagePullSecrets(test.imagePullSecrets, test.namespace)
+		// Spare capacity of the pod spec's secrets must not be written to.
+		imagePullSecrets := append(make([]corev1.LocalObjectReference, 0, len(test.imagePullSecrets)+1), test.imagePullSecrets...)
+
+		secrets, err := w.GetImagePullSecrets(context.Background(), imagePullSecrets, test.serviceAccountName, test.namespace)
 
 		assert.Equal(t, test.err, err)
 		assert.ElementsMatch(t, test.expected, secrets)
+		assert.Equal(t, corev1.LocalObjectReference{}, imagePullSecrets[:len(imagePullSecrets)+1][len(imagePullSecrets)])
+	}
+}
+
+func TestCachedSecretRetriever(t *testing.T) {
+	cached := newDockerSecret(corev1.DockerConfigJsonKey, "docker.io", base64EncodedCredentials)
+	cached.Name, cached.Namespace, cached.Type = "cached", "team", corev1.SecretTypeDockerConfigJson
+
+	kubeClient := fake.NewSimpleClientset(cached)
+	fallback := &testSecretRetriever{
+		secrets: map[string]*corev1.Secret{
+			"uncached": &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "uncached",
+				},
+			},
+		},
+		namespace: "team",
+	}
+
+	s := NewCachedSecretRetriever(kubeClient, fallback)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := s.Run(stopCh); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := s.Get("team", "cached")
+	assert.NoError(t, err)
+	assert.Equal(t, cached, secret)
+
+	secret, err = s.Get("team", "uncached")
+	assert.NoError(t, err)
+	assert.Equal(t, "uncached", secret.Name)
+
+	_, err = s.Get("other", "cached")
+	assert.True(t, apierrors.IsNotFound(err))
+
+	for _, action := range kubeClient.Actions() {
+		assert.NotEqual(t, "get", action.GetVerb())
 	}
 }
 
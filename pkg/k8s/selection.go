@@ -0,0 +1,59 @@
+package k8s
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// enabled reports whether updatey may touch the object. Its namespace has to be selected and the object must not opt out
+// with the EnabledAnnotation, in opt-in mode it has to opt in instead.
+func (w *Wrapper) enabled(object *podObject) bool {
+	if !w.namespaceSelected(object.namespace) {
+		return false
+	}
+
+	value := annotation(object.metadata, EnabledAnnotation)
+
+	if w.optIn {
+		return value == "true"
+	}
+	return value != "false"
+}
+
+// namespaceSelected reports whether objects of the namespace may be touched, kube-system never is.
+// Excluded namespaces take precedence over included ones, every namespace is included when none are.
+func (w *Wrapper) namespaceSelected(namespace string) bool {
+	if namespace == metav1.NamespaceSystem {
+		return false
+	}
+
+	for _, excluded := range w.excludedNamespaces {
+		if namespace == excluded {
+			return false
+		}
+	}
+
+	if len(w.includedNamespaces) == 0 {
+		return true
+	}
+
+	for _, included := range w.includedNamespaces {
+		if namespace == included {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredContainers returns the names of the containers listed in the IgnoreContainersAnnotation.
+func ignoredContainers(metadata []metadataRef) map[string]bool {
+	ignored := map[string]bool{}
+
+	for _, name := range strings.Split(annotation(metadata, IgnoreContainersAnnotation), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ignored[name] = true
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,97 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/jw-s/updatey/pkg/client/docker"
+	"github.com/jw-s/updatey/pkg/version"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// GetViolations returns a description of every container image in the admission request which must not be admitted and possibly an error.
+// Images must neither use the latest tag nor still contain a constraint, which happens when the constraint didn't match any tag.
+// Objects without a namespace of their own are in the namespace of the request.
+func (w *Wrapper) GetViolations(ctx context.Context, ar *v1beta1.AdmissionRequest) (violations []string, err error) {
+	object, err := decodePodObject(ar.Kind.Kind, ar.Object.Raw)
+	if err != nil || object == nil {
+		return nil, err
+	}
+
+	if object.namespace == "" {
+		object.namespace = ar.Namespace
+	}
+
+	return w.objectViolations(ctx, object)
+}
+
+// objectViolations returns the violations of an object, objects and containers updatey is disabled for are always admitted.
+func (w *Wrapper) objectViolations(ctx context.Context, object *podObject) (violations []string, err error) {
+	if !w.enabled(object) {
+		return nil, nil
+	}
+
+	ignored := ignoredContainers(object.metadata)
+
+	for _, containerType := range containerTypes {
+		for _, container := range containersOf(object.spec, containerType) {
+			if ignored[container.Name] {
+				continue
+			}
+
+			ref, err := docker.ParseReference(container.Image)
+			if err != nil {
+				glog.Error(err)
+				continue
+			}
+
+			if ref.Digest != "" {
+				// Images pinned to a digest can't change, regardless of their tag.
+				continue
+			}
+
+			if ref.ImplicitTag {
+				violations = append(violations, fmt.Sprintf("container %q: image %q implicitly uses the latest tag", container.Name, container.Image))
+				continue
+			}
+
+			if ref.Tag == "latest" {
+				violations = append(violations, fmt.Sprintf("container %q: image %q uses the latest tag", container.Name, container.Image))
+				continue
+			}
+
+			if version.IsConstraint(ref.Tag) {
+				violations = append(violations, w.constraintViolation(ctx, object, container.Name, container.Image, ref.Repository, ref.Tag))
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// constraintViolation explains why an image still contains a constraint.
+// Every tag is listed, so the number of tags the constraint matched none of is the number of tags in the repository.
+func (w *Wrapper) constraintViolation(ctx context.Context, object *podObject, containerName, image, repository, constraint string) string {
+	secrets, err := w.GetImagePullSecrets(ctx, object.spec.ImagePullSecrets, object.spec.ServiceAccountName, object.namespace)
+	if err != nil {
+		glog.Error(err)
+	}
+
+	tags, _, err := w.tags(ctx, repository, image, constraint, false, secrets)
+	if err != nil {
+		return fmt.Sprintf("container %q: image %q still contains a version constraint, the tags of %s could not be listed: %v", containerName, image, repository, err)
+	}
+
+	result, err := w.resolver.Resolve(constraint, tags)
+	if err == nil {
+		return fmt.Sprintf("container %q: image %q still contains a version constraint which resolves to %s:%s", containerName, image, repository, result.Version)
+	}
+
+	if version.IsNoMatch(err) && result != nil {
+		return fmt.Sprintf("container %q: constraint %q of image %q matched none of the %d tags of %s", containerName, constraint, image, result.Candidates, repository)
+	}
+
+	return fmt.Sprintf("container %q: image %q still contains a version constraint which can't be resolved: %v", containerName, image, err)
+}
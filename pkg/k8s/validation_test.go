@@ -0,0 +1,194 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jw-s/updatey/pkg/version"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestGetViolations(t *testing.T) {
+	tests := []struct {
+		o        runtime.Object
+		expected []string
+	}{
+		{
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name:  "init",
+							Image: "busybox:1.0.0",
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:1.14.2",
+						},
+						{
+							Name:  "pinned",
+							Image: "nginx@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+						},
+					},
+				},
+			},
+		},
+		{
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name:  "init",
+							Image: "busybox",
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:latest",
+						},
+					},
+				},
+			},
+			expected: []string{
+				`container "init": image "busybox" implicitly uses the latest tag`,
+				`container "nginx": image "nginx:latest" uses the latest tag`,
+			},
+		},
+		{
+			o: &appsv1.Deployment{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Deployment",
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name:  "nginx",
+									Image: "nginx:^9.0",
+								},
+								{
+									Name:  "sidecar",
+									Image: "nginx:~1.14",
+								},
+								{
+									Name:  "unknown",
+									Image: "quay.io/unknown:^1.0",
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: []string{
+				`container "nginx": constraint "^9.0" of image "nginx:^9.0" matched none of the 2 tags of nginx`,
+				`container "sidecar": image "nginx:~1.14" still contains a version constraint which resolves to nginx:1.14.2`,
+				`container "unknown": image "quay.io/unknown:^1.0" still contains a version constraint, the tags of quay.io/unknown could not be listed: anonymous access: repository unknown`,
+			},
+		},
+		{
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{EnabledAnnotation: "false"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:latest",
+						},
+					},
+				},
+			},
+		},
+		{
+			o: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Pod",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{IgnoreContainersAnnotation: "sidecar"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:latest",
+						},
+						{
+							Name:  "sidecar",
+							Image: "busybox",
+						},
+					},
+				},
+			},
+			expected: []string{
+				`container "nginx": image "nginx:latest" uses the latest tag`,
+			},
+		},
+		{
+			o: &corev1.Service{
+				TypeMeta: metav1.TypeMeta{
+					Kind: "Service",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		dockerClient := &testRepositoryClient{
+			tags: map[string][]string{
+				"nginx": {"1.14.1", "1.14.2"},
+			},
+		}
+		w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient)
+
+		violations, err := w.GetViolations(context.Background(), createAdmissionRequest(test.o))
+
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, violations)
+	}
+}
+
+func TestGetViolationsListsEveryTag(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Pod",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "nginx",
+					Image: "nginx:<=1.4.2",
+				},
+			},
+		},
+	}
+
+	dockerClient := &testPagedClient{
+		pages: [][]string{{"1.4.1", "1.4.2"}, {"1.4.3", "1.5.0"}},
+	}
+	w := New(&testSecretRetriever{}, version.NewSemVersionResolver(), dockerClient)
+
+	violations, err := w.GetViolations(context.Background(), createAdmissionRequest(pod))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`container "nginx": image "nginx:<=1.4.2" still contains a version constraint which resolves to nginx:1.4.2`}, violations)
+	assert.Equal(t, 4, dockerClient.listed)
+}
@@ -1,6 +1,10 @@
 package k8s
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/jw-s/updatey/pkg/client/docker"
 	"github.com/jw-s/updatey/pkg/version"
 	"k8s.io/api/admission/v1beta1"
@@ -9,22 +13,158 @@ import (
 
 // Interface defines the functionality related to kubernetes.
 type Interface interface {
-	GetPatches(ar *v1beta1.AdmissionRequest) (patches []*JSONPatch, err error)
-	GetImagePullSecrets(imagePullSecrets []corev1.LocalObjectReference, namespace string) ([]*corev1.Secret, error)
+	GetPatches(ctx context.Context, ar *v1beta1.AdmissionRequest) (patches []*JSONPatch, err error)
+	GetObjectPatches(ctx context.Context, kind string, raw []byte) (patches []*JSONPatch, err error)
+	GetViolations(ctx context.Context, ar *v1beta1.AdmissionRequest) (violations []string, err error)
+	GetImagePullSecrets(ctx context.Context, imagePullSecrets []corev1.LocalObjectReference, serviceAccountName, namespace string) ([]*corev1.Secret, error)
 }
 
 // Wrapper is a simple helper type to wrap the kubernetes client.
 type Wrapper struct {
-	secretRetriever SecretInterface
-	resolver        version.Resolver
-	dockerClient    docker.Interface
+	secretRetriever         SecretInterface
+	serviceAccountRetriever ServiceAccountInterface
+	resolver                version.Resolver
+	dockerClient            docker.Interface
+	pinDigest               bool
+	resolveTimeout          time.Duration
+	concurrency             int
+	credentialStrategy      CredentialStrategy
+	credentialSources       []docker.CredentialSource
+	platforms               []docker.Platform
+	minAge                  time.Duration
+	optIn                   bool
+	includedNamespaces      []string
+	excludedNamespaces      []string
+}
+
+// CredentialStrategy determines in which order image pull secrets and anonymous access are tried when listing tags.
+type CredentialStrategy string
+
+const (
+	// AuthFirst tries every image pull secret before anonymous access, registries may filter or rate limit anonymous listings.
+	AuthFirst CredentialStrategy = "auth-first"
+	// AnonymousFirst tries anonymous access before any image pull secret.
+	AnonymousFirst CredentialStrategy = "anonymous-first"
+	// AuthOnly only tries image pull secrets.
+	AuthOnly CredentialStrategy = "auth-only"
+)
+
+// ParseCredentialStrategy returns the credential strategy of the given name and possibily an error.
+func ParseCredentialStrategy(name string) (CredentialStrategy, error) {
+	switch strategy := CredentialStrategy(name); strategy {
+	case AuthFirst, AnonymousFirst, AuthOnly:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown credential strategy: %s", name)
+}
+
+const (
+	defaultResolveTimeout = 25 * time.Second
+	defaultConcurrency    = 4
+)
+
+// Option configures optional behaviour of a Wrapper.
+type Option func(*Wrapper)
+
+// WithDigestPinning pins every resolved image to its content digest, regardless of the PinDigestAnnotation.
+func WithDigestPinning(pinDigest bool) Option {
+	return func(w *Wrapper) {
+		w.pinDigest = pinDigest
+	}
+}
+
+// WithResolveTimeout limits how long resolving all images of an object may take,
+// images which aren't resolved in time are left untouched. Timeouts which aren't positive use the default.
+func WithResolveTimeout(timeout time.Duration) Option {
+	return func(w *Wrapper) {
+		if timeout <= 0 {
+			timeout = defaultResolveTimeout
+		}
+		w.resolveTimeout = timeout
+	}
+}
+
+// WithConcurrency sets how many images of an object are resolved concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(w *Wrapper) {
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		w.concurrency = concurrency
+	}
+}
+
+// WithServiceAccounts includes the image pull secrets of the service account of a pod when authenticating to registries.
+func WithServiceAccounts(serviceAccountRetriever ServiceAccountInterface) Option {
+	return func(w *Wrapper) {
+		w.serviceAccountRetriever = serviceAccountRetriever
+	}
+}
+
+// WithCredentialStrategy sets in which order image pull secrets and anonymous access are tried, defaults to AuthFirst.
+func WithCredentialStrategy(strategy CredentialStrategy) Option {
+	return func(w *Wrapper) {
+		w.credentialStrategy = strategy
+	}
+}
+
+// WithCredentialSources adds sources of credentials which are tried after the image pull secrets, e.g. the docker config of the node.
+func WithCredentialSources(sources ...docker.CredentialSource) Option {
+	return func(w *Wrapper) {
+		w.credentialSources = append(w.credentialSources, sources...)
+	}
+}
+
+// WithPlatforms sets the platforms resolved images have to publish when a pod doesn't constrain the architecture of its nodes,
+// pods constraining it with the kubernetes.io/arch label require the platforms of those nodes instead.
+func WithPlatforms(platforms ...docker.Platform) Option {
+	return func(w *Wrapper) {
+		w.platforms = append(w.platforms, platforms...)
+	}
+}
+
+// WithMinimumAge only chooses tags whose image was created at least the given time ago, unless the MinAgeAnnotation of an object overrides it.
+func WithMinimumAge(minAge time.Duration) Option {
+	return func(w *Wrapper) {
+		w.minAge = minAge
+	}
+}
+
+// WithOptIn only touches objects which opt in with the EnabledAnnotation set to "true", instead of every object which doesn't opt out.
+func WithOptIn(optIn bool) Option {
+	return func(w *Wrapper) {
+		w.optIn = optIn
+	}
+}
+
+// WithIncludedNamespaces only touches objects in the given namespaces, every namespace is included by default.
+func WithIncludedNamespaces(namespaces ...string) Option {
+	return func(w *Wrapper) {
+		w.includedNamespaces = append(w.includedNamespaces, namespaces...)
+	}
+}
+
+// WithExcludedNamespaces never touches objects in the given namespaces, even when they are included. kube-system is always excluded.
+func WithExcludedNamespaces(namespaces ...string) Option {
+	return func(w *Wrapper) {
+		w.excludedNamespaces = append(w.excludedNamespaces, namespaces...)
+	}
 }
 
 // New returns a new Wrapper.
-func New(secretRetriever SecretInterface, resolver version.Resolver, dockerClient docker.Interface) *Wrapper {
-	return &Wrapper{
-		secretRetriever: secretRetriever,
-		resolver:        resolver,
-		dockerClient:    dockerClient,
+func New(secretRetriever SecretInterface, resolver version.Resolver, dockerClient docker.Interface, opts ...Option) *Wrapper {
+	w := &Wrapper{
+		secretRetriever:    secretRetriever,
+		resolver:           resolver,
+		dockerClient:       dockerClient,
+		resolveTimeout:     defaultResolveTimeout,
+		concurrency:        defaultConcurrency,
+		credentialStrategy: AuthFirst,
 	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
 }
@@ -0,0 +1,17 @@
+package version
+
+import "strings"
+
+// IsConstraint reports whether the tag is a version constraint rather than a literal tag like "1.14.2" or "latest".
+func IsConstraint(tag string) bool {
+	if strings.ContainsAny(tag, "^~<>=!*|, ") {
+		return true
+	}
+
+	for _, part := range strings.Split(strings.TrimPrefix(tag, "v"), ".") {
+		if part == "x" || part == "X" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,29 +1,79 @@
 package version
 
 import (
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/Masterminds/semver"
 )
 
 // Resolver determines what version to use.
 type Resolver interface {
-	Resolve(string, []string) string
+	Resolve(string, []string) (*Result, error)
+}
+
+// Bounded is implemented by resolvers which can prove that no version beyond the ones seen so far would be chosen,
+// allowing callers to stop listing versions early.
+type Bounded interface {
+	// Final reports whether none of the remaining versions could be chosen over the versions seen so far.
+	Final(constraint string, versions []string) bool
+}
+
+// Result describes which version a constraint resolved to and why the other versions weren't chosen.
+type Result struct {
+	// Version is the chosen version, literal tags which don't match any version are kept as they are.
+	Version string
+	// Constraint reports whether the input was a version constraint rather than a literal tag like "1.14.2" or "latest".
+	Constraint bool
+	// Candidates is the number of versions which were considered.
+	Candidates int
+	// Rejected holds the reason why each version wasn't chosen, keyed by version.
+	Rejected map[string]string
+}
+
+// NoMatchError is returned when none of the versions satisfy a constraint.
+type NoMatchError struct {
+	Constraint string
+	Candidates int
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("constraint %q matched none of the %d versions", e.Constraint, e.Candidates)
+}
+
+// IsNoMatch reports whether the error is a NoMatchError.
+func IsNoMatch(err error) bool {
+	_, ok := err.(*NoMatchError)
+	return ok
 }
 
 type semVersionResolver struct{}
 
+var _ Bounded = semVersionResolver{}
+
 // NewSemVersionResolver is a resolver which uses the Semantic Version spec.
 func NewSemVersionResolver() Resolver {
 	return semVersionResolver{}
 }
 
 // Resolve determines the version to return based on a Semantic version constraint and a list of versions which may meet the constaint.
+// Literal tags resolve to themselves when they aren't a valid constraint or no version matches them, unsatisfiable and invalid constraints return an error.
 // For more information about defining constaints: https://github.com/Masterminds/semver#checking-version-constraints
-func (semVersionResolver) Resolve(constraint string, versions []string) string {
+func (semVersionResolver) Resolve(constraint string, versions []string) (*Result, error) {
+	result := &Result{
+		Version:    constraint,
+		Constraint: IsConstraint(constraint),
+		Candidates: len(versions),
+		Rejected:   map[string]string{},
+	}
+
 	c, err := semver.NewConstraint(constraint)
 	if err != nil {
-		return constraint
+		if result.Constraint {
+			return result, fmt.Errorf("invalid constraint %q: %v", constraint, err)
+		}
+		return result, nil
 	}
 
 	var compatibles []*semver.Version
@@ -31,18 +81,59 @@ func (semVersionResolver) Resolve(constraint string, versions []string) string {
 	for _, version := range versions {
 		v, err := semver.NewVersion(version)
 		if err != nil {
+			result.Rejected[version] = "not a semantic version"
 			continue
 		}
 
-		if c.Check(v) {
-			compatibles = append(compatibles, v)
+		if !c.Check(v) {
+			result.Rejected[version] = fmt.Sprintf("does not satisfy %q", constraint)
+			continue
 		}
+
+		compatibles = append(compatibles, v)
 	}
 
-	sort.Sort(sort.Reverse(semver.Collection(compatibles)))
 	if len(compatibles) == 0 {
-		return constraint
+		if result.Constraint {
+			return result, &NoMatchError{Constraint: constraint, Candidates: len(versions)}
+		}
+		return result, nil
+	}
+
+	sort.Sort(sort.Reverse(semver.Collection(compatibles)))
+
+	result.Version = compatibles[0].Original()
+	for _, v := range compatibles[1:] {
+		result.Rejected[v.Original()] = fmt.Sprintf("older than %s", result.Version)
+	}
+
+	return result, nil
+}
+
+// Final reports whether the version resolved so far is the highest one the constraint allows.
+// This is only provable for constraints describing a single contiguous range without pre releases, e.g. "<=1.4.2" once "1.4.2"
+// was seen, while "<1.2 || >=2.0", ">=1.0, !=1.2.4" or "^1.0-beta" are never final.
+func (r semVersionResolver) Final(constraint string, versions []string) bool {
+	if strings.Contains(constraint, "||") || strings.Contains(constraint, "!=") || strings.Contains(constraint, "-") || !IsConstraint(constraint) {
+		return false
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+
+	result, err := r.Resolve(constraint, versions)
+	if err != nil {
+		return false
+	}
+
+	v, err := semver.NewVersion(result.Version)
+	if err != nil {
+		return false
 	}
 
-	return compatibles[0].Original()
+	// A range containing the resolved version but not the next patch contains no higher version without a pre release.
+	next := v.IncPatch()
+	return !c.Check(&next)
 }
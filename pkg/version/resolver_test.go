@@ -11,6 +11,7 @@ func TestSemVerResolver(t *testing.T) {
 		constraint       string
 		possibleVersions []string
 		expected         string
+		err              bool
 	}{
 		{
 			constraint: "^0.5.0",
@@ -22,6 +23,7 @@ func TestSemVerResolver(t *testing.T) {
 				"0.5-beta",
 			},
 			expected: "^0.5.0",
+			err:      true,
 		},
 		{
 			constraint: "^0.5.0",
@@ -69,8 +71,264 @@ func TestSemVerResolver(t *testing.T) {
 	resolver := NewSemVersionResolver()
 
 	for _, test := range tests {
-		result := resolver.Resolve(test.constraint, test.possibleVersions)
+		result, err := resolver.Resolve(test.constraint, test.possibleVersions)
 
-		assert.Equal(t, test.expected, result)
+		assert.Equal(t, test.err, err != nil, test.constraint)
+		assert.Equal(t, test.expected, result.Version, test.constraint)
+	}
+}
+
+func TestSemVerResolverResult(t *testing.T) {
+	tests := []struct {
+		constraint       string
+		possibleVersions []string
+		expected         *Result
+		noMatch          bool
+	}{
+		{
+			constraint:       "~1.14",
+			possibleVersions: []string{"latest", "1.13.0", "1.14.1", "1.14.2"},
+			expected: &Result{
+				Version:    "1.14.2",
+				Constraint: true,
+				Candidates: 4,
+				Rejected: map[string]string{
+					"latest": "not a semantic version",
+					"1.13.0": `does not satisfy "~1.14"`,
+					"1.14.1": "older than 1.14.2",
+				},
+			},
+		},
+		{
+			constraint:       "^2.0",
+			possibleVersions: []string{"1.14.2"},
+			expected: &Result{
+				Version:    "^2.0",
+				Constraint: true,
+				Candidates: 1,
+				Rejected: map[string]string{
+					"1.14.2": `does not satisfy "^2.0"`,
+				},
+			},
+			noMatch: true,
+		},
+		{
+			constraint:       "latest",
+			possibleVersions: []string{"1.14.2"},
+			expected: &Result{
+				Version:    "latest",
+				Candidates: 1,
+				Rejected:   map[string]string{},
+			},
+		},
+		{
+			constraint:       "1.15.0",
+			possibleVersions: []string{"1.14.2"},
+			expected: &Result{
+				Version:    "1.15.0",
+				Candidates: 1,
+				Rejected: map[string]string{
+					"1.14.2": `does not satisfy "1.15.0"`,
+				},
+			},
+		},
+	}
+
+	resolver := NewSemVersionResolver()
+
+	for _, test := range tests {
+		result, err := resolver.Resolve(test.constraint, test.possibleVersions)
+
+		assert.Equal(t, test.noMatch, IsNoMatch(err), test.constraint)
+		assert.Equal(t, test.expected, result, test.constraint)
+	}
+}
+
+func TestFinal(t *testing.T) {
+	tests := []struct {
+		resolver   Bounded
+		constraint string
+		versions   []string
+		expected   bool
+	}{
+		{resolver: semVersionResolver{}, constraint: "<=1.4.2", versions: []string{"1.4.1", "1.4.2"}, expected: true},
+		{resolver: semVersionResolver{}, constraint: "<=1.4.2", versions: []string{"1.4.1"}},
+		{resolver: semVersionResolver{}, constraint: ">=1.0, <1.5", versions: []string{"1.4.9"}},
+		{resolver: semVersionResolver{}, constraint: "~1.14.2", versions: []string{"1.14.2"}},
+		{resolver: semVersionResolver{}, constraint: "1.2 - 1.4", versions: []string{"1.4.0"}},
+		{resolver: semVersionResolver{}, constraint: "<=1.4.2 || >=3.0", versions: []string{"1.4.2"}},
+		{resolver: semVersionResolver{}, constraint: ">=1.0, !=1.2.4", versions: []string{"1.2.3"}},
+		{resolver: semVersionResolver{}, constraint: ">=1.0, <=1.2.3, !=1.2.1", versions: []string{"1.2.3"}},
+		{resolver: semVersionResolver{}, constraint: "~1.14", versions: []string{"1.14.2"}},
+		{resolver: semVersionResolver{}, constraint: "<=1.4.2-beta", versions: []string{"1.4.2-alpha"}},
+		{resolver: semVersionResolver{}, constraint: "^9.0", versions: []string{"1.4.2"}},
+		{resolver: semVersionResolver{}, constraint: "1.4.2", versions: []string{"1.4.2"}},
+		{resolver: semVersionResolver{}, constraint: "*", versions: []string{"1.4.2"}},
+		{resolver: variantResolver{}, constraint: "<=1.15.9-alpine", versions: []string{"1.15.9", "1.15.8-alpine"}},
+		{resolver: variantResolver{}, constraint: "<=1.15.9-alpine", versions: []string{"1.15.9-alpine", "1.15.8-alpine"}, expected: true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.resolver.Final(test.constraint, test.versions), "%s %v", test.constraint, test.versions)
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected bool
+	}{
+		{tag: "~1.14", expected: true},
+		{tag: "^1.15", expected: true},
+		{tag: ">=1.2, <2", expected: true},
+		{tag: "1.2 - 1.4", expected: true},
+		{tag: "1.x", expected: true},
+		{tag: "1.*", expected: true},
+		{tag: "1.14", expected: false},
+		{tag: "1.14.2", expected: false},
+		{tag: "1.15.8-alpine", expected: false},
+		{tag: "latest", expected: false},
+		{tag: "xenial", expected: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, IsConstraint(test.tag), test.tag)
+	}
+}
+
+func TestVariantResolver(t *testing.T) {
+	tests := []struct {
+		constraint       string
+		possibleVersions []string
+		expected         string
+		err              bool
+	}{
+		{
+			constraint: "^1.15-alpine",
+			possibleVersions: []string{
+				"alpine",
+				"latest",
+				"1.15.8",
+				"1.15.8-alpine",
+				"1.15.9",
+				"1.15.9-alpine",
+				"1.16.0-rc1-alpine",
+				"1.15.10-perl",
+			},
+			expected: "1.15.9-alpine",
+		},
+		{
+			constraint: "~1.15.8-alpine",
+			possibleVersions: []string{
+				"1.15.8-alpine",
+				"1.15.9-alpine3.9",
+				"1.15.10",
+			},
+			expected: "1.15.8-alpine",
+		},
+		{
+			constraint: "^1.15",
+			possibleVersions: []string{
+				"1.15.8",
+				"1.15.9-alpine",
+				"1.15.9-slim",
+			},
+			expected: "1.15.8",
+		},
+		{
+			constraint: "~3.7-slim",
+			possibleVersions: []string{
+				"3.7.2-slim",
+				"3.7.3-slim",
+				"3.7.4-slim-bullseye",
+				"3.8.0-slim",
+			},
+			expected: "3.7.3-slim",
+		},
+		{
+			constraint: "^3.9-slim-bullseye",
+			possibleVersions: []string{
+				"3.9.1-slim-bullseye",
+				"3.10.2-slim-bullseye",
+				"3.10.3-bullseye",
+				"3.10.4-slim",
+			},
+			expected: "3.10.2-slim-bullseye",
+		},
+		{
+			constraint: "^1.15-alpine",
+			possibleVersions: []string{
+				"1.15.8-alpine",
+				"1.15.9-rc1-alpine",
+				"1.15.9-rc1",
+			},
+			expected: "1.15.8-alpine",
+		},
+		{
+			constraint: ">=1.16.0-rc1",
+			possibleVersions: []string{
+				"1.15.8",
+				"1.16.0-rc1",
+				"1.16.0-rc2",
+				"1.16.0-rc2-alpine",
+			},
+			expected: "1.16.0-rc2",
+		},
+		{
+			constraint: ">=1.16.0-rc1-alpine",
+			possibleVersions: []string{
+				"1.16.0-rc1-alpine",
+				"1.16.0-rc2",
+				"1.16.0-rc2-alpine",
+			},
+			expected: "1.16.0-rc2-alpine",
+		},
+		{
+			constraint: "^2.0-alpine",
+			possibleVersions: []string{
+				"1.15.8-alpine",
+			},
+			expected: "^2.0-alpine",
+			err:      true,
+		},
+		{
+			constraint:       "@",
+			possibleVersions: []string{},
+			expected:         "@",
+		},
+	}
+
+	resolver := NewVariantResolver()
+
+	for _, test := range tests {
+		result, err := resolver.Resolve(test.constraint, test.possibleVersions)
+
+		assert.Equal(t, test.err, err != nil, test.constraint)
+		assert.Equal(t, test.expected, result.Version, test.constraint)
+	}
+}
+
+func TestSplitVariant(t *testing.T) {
+	tests := []struct {
+		s               string
+		expectedVersion string
+		expectedVariant string
+	}{
+		{s: "1.15.8", expectedVersion: "1.15.8"},
+		{s: "1.15.8-alpine", expectedVersion: "1.15.8", expectedVariant: "alpine"},
+		{s: "1.15.8-alpine3.9", expectedVersion: "1.15.8", expectedVariant: "alpine3.9"},
+		{s: "3.7.2-slim-bullseye", expectedVersion: "3.7.2", expectedVariant: "slim-bullseye"},
+		{s: "1.16.0-rc1", expectedVersion: "1.16.0-rc1"},
+		{s: "1.16.0-rc.1-alpine", expectedVersion: "1.16.0-rc.1", expectedVariant: "alpine"},
+		{s: "1.0.0-1", expectedVersion: "1.0.0-1"},
+		{s: "^1.15-alpine", expectedVersion: "^1.15", expectedVariant: "alpine"},
+		{s: ">=1.2, <2-slim", expectedVersion: ">=1.2, <2", expectedVariant: "slim"},
+		{s: "alpine", expectedVersion: "alpine"},
+	}
+
+	for _, test := range tests {
+		version, variant := SplitVariant(test.s)
+		assert.Equal(t, test.expectedVersion, version, test.s)
+		assert.Equal(t, test.expectedVariant, variant, test.s)
 	}
 }
@@ -0,0 +1,103 @@
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	variantSuffix = regexp.MustCompile(`-([A-Za-z][0-9A-Za-z.]*(?:-[0-9A-Za-z.]+)*)$`)
+	prerelease    = regexp.MustCompile(`(?i)^(alpha|beta|rc|pre|preview|dev|snapshot)[0-9.]*$`)
+)
+
+type variantResolver struct{}
+
+var _ Bounded = variantResolver{}
+
+// NewVariantResolver is a resolver which uses the Semantic Version spec and respects variant suffixes like "-alpine".
+// Only versions carrying the same variant as the constraint are considered.
+func NewVariantResolver() Resolver {
+	return variantResolver{}
+}
+
+// Resolve determines the version to return based on a Semantic version constraint with an optional variant suffix, e.g. "^1.15-alpine",
+// and a list of versions which may meet the constraint.
+func (variantResolver) Resolve(constraint string, versions []string) (*Result, error) {
+	constraintVersion, variant := SplitVariant(constraint)
+
+	var candidates []string
+	tags := map[string]string{}
+	rejected := map[string]string{}
+
+	for _, version := range versions {
+		v, tagVariant := SplitVariant(version)
+		if tagVariant != variant {
+			rejected[version] = fmt.Sprintf("variant %q differs from %q", tagVariant, variant)
+			continue
+		}
+		if tag, exists := tags[v]; exists {
+			rejected[version] = fmt.Sprintf("same version as %s", tag)
+			continue
+		}
+		tags[v] = version
+		candidates = append(candidates, v)
+	}
+
+	result, err := semVersionResolver{}.Resolve(constraintVersion, candidates)
+
+	for v, reason := range result.Rejected {
+		rejected[tags[v]] = reason
+	}
+
+	resolved := &Result{
+		Version:    constraint,
+		Constraint: result.Constraint,
+		Candidates: len(versions),
+		Rejected:   rejected,
+	}
+
+	if err != nil {
+		if IsNoMatch(err) {
+			return resolved, &NoMatchError{Constraint: constraint, Candidates: len(versions)}
+		}
+		return resolved, err
+	}
+
+	if tag, exists := tags[result.Version]; exists {
+		resolved.Version = tag
+	}
+	return resolved, nil
+}
+
+// Final reports whether the version resolved so far among the versions of the variant is the highest one the constraint allows.
+func (variantResolver) Final(constraint string, versions []string) bool {
+	constraintVersion, variant := SplitVariant(constraint)
+
+	var candidates []string
+	for _, version := range versions {
+		if v, tagVariant := SplitVariant(version); tagVariant == variant {
+			candidates = append(candidates, v)
+		}
+	}
+
+	return semVersionResolver{}.Final(constraintVersion, candidates)
+}
+
+// SplitVariant splits a tag or constraint into its version and variant suffix, e.g. "1.15.8-alpine" into "1.15.8" and "alpine".
+// Prerelease identifiers like "rc1" directly following the version remain part of it.
+func SplitVariant(s string) (version, variant string) {
+	loc := variantSuffix.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s, ""
+	}
+
+	version = s[:loc[0]]
+	identifiers := strings.Split(s[loc[2]:loc[3]], "-")
+	for len(identifiers) > 0 && prerelease.MatchString(identifiers[0]) {
+		version += "-" + identifiers[0]
+		identifiers = identifiers[1:]
+	}
+
+	return version, strings.Join(identifiers, "-")
+}